@@ -0,0 +1,227 @@
+/*
+barrier.go process barriers
+
+A barrier makes BrCount processes rendezvous. Each arriving process
+blocks, in the PrBarrier state, on the queue of the barrier in Queuetab,
+until the last one arrives. The last arrival opens the barrier: all the
+waiting processes are made ready under a single deferred rescheduling,
+the generation number is incremented and the barrier is ready to be
+used again by the next round of arrivals.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+// BrFree state: barrier table entry is available
+const BrFree uint8 = 0
+
+// BrUsed state: barrier table entry is used
+const BrUsed uint8 = 1
+
+// BarEntry struct is the barrier table entry
+type BarEntry struct {
+	BrState   uint8  // BrFree or BrUsed
+	BrCount   int32  // number of processes that must arrive to open the barrier
+	BrArrived int32  // number of processes arrived in the current generation
+	BrNext    int32  // next arrival number of the current generation, never goes down
+	BrGen     uint32 // generation, incremented every time the barrier opens
+	BrQueue   Qid16  // queue id of the arrived processes, in arrival order
+}
+
+// BarTab is the barrier table
+var BarTab [NBARRIER]BarEntry
+
+// nextbar is the next barrier index to try to allocate
+var nextbar Brid32 = 0
+
+// IsBadBarrier function checks if barrier id is bad
+func IsBadBarrier(b Brid32) bool {
+	return b < 0 || int(b) >= NBARRIER || BarTab[b].BrState == BrFree
+}
+
+// BarrierInit function initialize the barrier table and allocate the
+// waiting queue of every entry
+func BarrierInit() error {
+	for i := 0; i < NBARRIER; i++ {
+		BarTab[i].BrState = BrFree
+
+		q, err := NewQueue()
+		if err != OK {
+			return err
+		}
+		BarTab[i].BrQueue = q
+	}
+
+	nextbar = 0
+
+	return OK
+}
+
+// BarrierCreate function allocates a barrier for count processes
+func BarrierCreate(count int32) (Brid32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if count < 1 {
+		return NoneBarrier, ErrSYSERR
+	}
+
+	for i := 0; i < NBARRIER; i++ {
+		b := nextbar
+
+		nextbar++
+		if int(nextbar) >= NBARRIER {
+			nextbar = 0
+		}
+
+		bptr := &BarTab[b]
+		if bptr.BrState == BrFree {
+			bptr.BrState = BrUsed
+			bptr.BrCount = count
+			bptr.BrArrived = 0
+			bptr.BrNext = 0
+			bptr.BrGen = 0
+			return b, OK
+		}
+	}
+
+	return NoneBarrier, ErrSYSERR
+}
+
+// BarrierDelete function deletes a barrier and releases the waiting processes
+func BarrierDelete(b Brid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadBarrier(b) {
+		return ErrSYSERR
+	}
+
+	bptr := &BarTab[b]
+	bptr.BrState = BrFree
+	bptr.BrArrived = 0
+	bptr.BrNext = 0
+
+	ReschedCntl(DeferStart)
+	for pid, err := GetFirst(bptr.BrQueue); err == OK; pid, err = GetFirst(bptr.BrQueue) {
+		WakeReady(pid, WkDeleted)
+	}
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// barwait function does the work of BarrierWait and BarrierWaitTime.
+// maxwait is the timeout in milliseconds, negative means no timeout
+func barwait(b Brid32, maxwait int32) (int32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadBarrier(b) {
+		return -1, ErrSYSERR
+	}
+
+	bptr := &BarTab[b]
+	// a withdrawn arrival keeps its number, so numbers are never reused
+	arrival := bptr.BrNext
+	bptr.BrNext++
+	bptr.BrArrived++
+
+	if bptr.BrArrived >= bptr.BrCount { // the last one, open the barrier
+		bptr.BrArrived = 0
+		bptr.BrNext = 0
+		bptr.BrGen++
+
+		ReschedCntl(DeferStart)
+		for pid, err := GetFirst(bptr.BrQueue); err == OK; pid, err = GetFirst(bptr.BrQueue) {
+			Ready(pid)
+		}
+		ReschedCntl(DeferStop)
+
+		return arrival, OK
+	}
+
+	if maxwait == 0 { // not allowed to wait at all, nobody saw the arrival
+		bptr.BrArrived--
+		bptr.BrNext--
+		return -1, ErrTIMEOUT
+	}
+
+	prptr := &Proctab[CurrPid]
+	prptr.PrState = PrBarrier
+	prptr.PrBar = b
+	prptr.PrWake = WkOK
+
+	Enqueue(CurrPid, bptr.BrQueue)
+	if maxwait > 0 {
+		SetTimer(maxwait)
+	}
+	Resched()
+
+	// the barrier opened, unless timed out or deleted
+	if prptr.PrWake != WkOK {
+		return -1, WakeErr(prptr.PrWake)
+	}
+
+	return arrival, OK
+}
+
+// BarrierWait function arrives at a barrier and blocks until all the processes
+// of the current generation have arrived. It returns the arrival order of the
+// caller in its generation, counted from 0; the last arrival never blocks.
+// The numbers of arrivals withdrawn by a timeout or Kill() are not reused,
+// so the numbers returned in a generation can have gaps
+func BarrierWait(b Brid32) (int32, error) {
+	return barwait(b, -1)
+}
+
+// BarrierWaitTime function is BarrierWait that gives up after maxwait
+// milliseconds with ErrTIMEOUT, withdrawing the arrival of the caller
+func BarrierWaitTime(b Brid32, maxwait int32) (int32, error) {
+	if maxwait < 0 {
+		return -1, ErrSYSERR
+	}
+	return barwait(b, maxwait)
+}
+
+// BarrierGen function returns the current generation of a barrier,
+// i.e. how many times it has opened
+func BarrierGen(b Brid32) (uint32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadBarrier(b) {
+		return 0, ErrSYSERR
+	}
+
+	return BarTab[b].BrGen, OK
+}
+
+// BarrierArrivals function returns the processes waiting at a barrier in the
+// current generation, in the order they arrived
+func BarrierArrivals(b Brid32) ([]Pid32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadBarrier(b) {
+		return nil, ErrSYSERR
+	}
+
+	q := BarTab[b].BrQueue
+	arrivals := make([]Pid32, 0, NPROC)
+	for curr := FirstID(q); curr != QueueTail(q); curr = Queuetab[curr].Qnext {
+		arrivals = append(arrivals, Pid32(curr))
+	}
+
+	return arrivals, OK
+}
+
+// barleave function withdraws the arrival of the waiting process pid,
+// when it times out or is killed (internal function assumes interrupts disabled)
+func barleave(pid Pid32) {
+	CancelTimer(pid)
+	GetItem(pid)
+	BarTab[Proctab[pid].PrBar].BrArrived--
+}
@@ -131,6 +131,7 @@ func GetBuf(poolid Bpid32) (unsafe.Pointer, error) {
 	//       retptr                \----------------/ remaining buffer pool
 
 	retptr := unsafe.Pointer(uintptr(unsafe.Pointer(bufptr)) + unsafe.Sizeof(Bpid32(0)))
+	Trace(TraceAlloc, int32(bpptr.BpSize), int32(poolid))
 
 	return retptr, OK
 }
@@ -166,6 +167,8 @@ func FreeBuf(bufaddr unsafe.Pointer) error {
 	// /|\                        |                /|\
 	// bpptr.BpNext               \----------------/ 
 
+	Trace(TraceFree, int32(bpptr.BpSize), int32(poolid))
+
 	// signal semaphore
 	Signal(bpptr.BpSem)
 
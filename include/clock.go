@@ -44,6 +44,11 @@ var count1000 uint32 = 0
 // clktime represent seconds since boot
 var clktime uint32
 
+// clkms function returns the milliseconds since boot, wrapping around
+func clkms() uint32 {
+	return clktime*1000 + count1000
+}
+
 // InsertDelta function insert a process in delta list using delay as the key
 // pid: process id of to be inserted;
 // q: the id of delta queue, which is actually the 'sleepq' variable;
@@ -53,6 +58,14 @@ func InsertDelta(pid Pid32, q Qid16, key int32) error {
 		return ErrSYSERR
 	}
 
+	insertd(Qid16(pid), q, key)
+
+	return OK
+}
+
+// insertd function insert node, either a process node or a timer node,
+// in delta list q using delay as the key
+func insertd(node Qid16, q Qid16, key int32) {
 	prev := QueueHead(q)
 	next := Queuetab[prev].Qnext
 
@@ -66,17 +79,16 @@ func InsertDelta(pid Pid32, q Qid16, key int32) error {
 	}
 
 	// insert new node between prev and next nodes
-	Queuetab[pid].Qnext = next
-	Queuetab[pid].Qprev = prev
-	Queuetab[prev].Qnext = Qid16(pid)
-	Queuetab[next].Qprev = Qid16(pid)
+	Queuetab[node].Qnext = next
+	Queuetab[node].Qprev = prev
+	Queuetab[node].Qkey = key
+	Queuetab[prev].Qnext = node
+	Queuetab[next].Qprev = node
 
 	if next != QueueTail(q) {
 		// substract the extra delay that the new node introduced
 		Queuetab[next].Qkey -= key
 	}
-
-	return OK
 }
 
 // Unsleep function removes a process from the sleep queue prematurely.
@@ -94,18 +106,86 @@ func Unsleep(pid Pid32) error {
 		return ErrSYSERR
 	}
 
-	pidNext := Queuetab[pid].Qnext
-	if int(pidNext) < NPROC { // make sure pidNext not head or tail node
-		// add the extra delay because process pid's sleep not actually end
-		Queuetab[pidNext].Qkey += Queuetab[pid].Qkey
+	unsleepd(Qid16(pid))
+
+	return OK
+}
+
+// unsleepd function removes node, either a process node or a timer node,
+// from the sleep queue (internal function assumes interrupts disabled)
+func unsleepd(node Qid16) {
+	next := Queuetab[node].Qnext
+	if next != QueueTail(sleepq) { // make sure next is not the tail node
+		// add the extra delay because the sleep of node not actually end
+		Queuetab[next].Qkey += Queuetab[node].Qkey
 	}
 
-	// get pid out of delta list
-	GetItem(pid)
+	// get node out of delta list
+	GetItem(Pid32(node))
+	Queuetab[node].Qnext = EMPTY
+	Queuetab[node].Qprev = EMPTY
+}
+
+// TimerNode function returns the index of the timer node of process pid in Queuetab.
+// A process waiting with a timeout stays on the queue it waits on with its
+// process node and on the sleep queue with its timer node, whichever
+// fires first removes it from the other.
+func TimerNode(pid Pid32) Qid16 {
+	return Qid16(NQENT + int(pid))
+}
+
+// SetTimer function puts the timer node of the current process on the sleep
+// queue, so it is awakened after delay milliseconds if nothing else readies it.
+// The caller must block the process right after, with interrupts disabled.
+func SetTimer(delay int32) error {
+	prptr := &Proctab[CurrPid]
+	if delay < 0 || prptr.PrTimed {
+		return ErrSYSERR
+	}
+
+	insertd(TimerNode(CurrPid), sleepq, delay)
+	prptr.PrTimed = true
 
 	return OK
 }
 
+// CancelTimer function removes the timer node of process pid from the sleep
+// queue if it is there. Ready() calls it, so a process readied by the event
+// it waits for no longer times out.
+func CancelTimer(pid Pid32) {
+	prptr := &Proctab[pid]
+	if !prptr.PrTimed {
+		return
+	}
+
+	unsleepd(TimerNode(pid))
+	prptr.PrTimed = false
+}
+
+// tmExpire function handles the expired timer of process pid by removing
+// the process from the queue it waits on and making it ready
+func tmExpire(pid Pid32) error {
+	prptr := &Proctab[pid]
+	prptr.PrTimed = false
+
+	switch prptr.PrState {
+	case PrWait:
+		// leave the semaphore queue and give back the count
+		GetItem(pid)
+		SemTab[prptr.PrSem].SCount++
+	case PrCond, PrEvent, PrSend, PrSelect:
+		// leave the condition variable, event group, sender or select queue
+		GetItem(pid)
+	case PrBarrier:
+		barleave(pid)
+	default:
+		// nothing to wait for anymore
+		return ErrSYSERR
+	}
+
+	return WakeReady(pid, WkTimeout)
+}
+
 // Wakeup function called by clock interrupt handler to awaken processes.
 // It is different with Unsleep() because Wakeup() only called when it IS
 // the time to awaken processes since they have sleeped JUST ENOUGH time
@@ -116,12 +196,20 @@ func Wakeup() {
 	// if delta list is: 0 -> 0 -> 0 -> 2 -> ...,
 	// then first three process's sleep period has end.
 	for NonEmpty(sleepq) && FirstKey(sleepq) <= 0 {
-		pid, err := Dequeue(sleepq)
+		node, err := Dequeue(sleepq)
 		if err != OK {
 			return
 		}
 
-		err = Ready(pid)
+		if int(node) >= NQENT { // a timer node, the timed wait has expired
+			pid := Pid32(int(node) - NQENT)
+			Trace(TraceWakeup, int32(pid), 1)
+			tmExpire(pid)
+			continue
+		}
+
+		Trace(TraceWakeup, int32(node), 0)
+		err = Ready(node)
 		if err != OK {
 			return
 		}
@@ -175,7 +263,7 @@ func RecvTime(maxWait int32) (Umsg32, error) {
 	defer Restore(mask)
 
 	prptr := &Proctab[CurrPid]
-	if prptr.PrHasMsg == false { // no message available yet
+	if !msgready(prptr) { // no message available yet
 		// sleep maxWait time waiting for message
 		err := InsertDelta(CurrPid, sleepq, maxWait)
 		if err != OK {
@@ -189,9 +277,9 @@ func RecvTime(maxWait int32) (Umsg32, error) {
 
 	// either message available or timer expired
 	msg := TimeoutMsg
-	if prptr.PrHasMsg { // message available
-		msg = prptr.PrMsg
-		prptr.PrHasMsg = false
+	if msgready(prptr) { // message available
+		msg, _ = msgget(prptr)
+		Trace(TraceRecv, int32(CurrPid), int32(msg))
 	}
 
 	return msg, OK
@@ -217,6 +305,12 @@ func ClkHandler() {
 		}
 	}
 
+	// look for deadlocks if the periodic check is configured
+	DeadlockTick()
+
+	// account CPU time of the current process, catching runaway processes
+	WdTick()
+
 	// decrement the preemption counter, and reschedule when
 	// remaining time reaches zero (time slice for current process is expired)
 	Preempt--
@@ -0,0 +1,204 @@
+/*
+condvar.go condition variables
+
+A condition variable is a queue in Queuetab where processes wait, in the
+PrCond state, until another process signals that the condition they are
+interested in may have changed. Waiting always happens together with a
+mutex (see mutex.go): the mutex is released and the process is queued
+atomically, and the mutex is locked again before the wait returns.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+// CFree state: condition variable table entry is available
+const CFree uint8 = 0
+
+// CUsed state: condition variable table entry is used
+const CUsed uint8 = 1
+
+// CondEntry struct is the condition variable table entry
+type CondEntry struct {
+	CState uint8 // CFree or CUsed
+	CQueue Qid16 // queue id of processes that are waiting on the condition
+}
+
+// CondTab is the condition variable table
+var CondTab [NCOND]CondEntry
+
+// nextcond is the next condition variable index to try to allocate
+var nextcond Cid32 = 0
+
+// IsBadCond function checks if condition variable id is bad
+func IsBadCond(cv Cid32) bool {
+	return cv < 0 || int(cv) >= NCOND || CondTab[cv].CState == CFree
+}
+
+// CondInit function initialize the condition variable table and allocate
+// the waiting queue of every entry
+func CondInit() error {
+	for i := 0; i < NCOND; i++ {
+		CondTab[i].CState = CFree
+
+		q, err := NewQueue()
+		if err != OK {
+			return err
+		}
+		CondTab[i].CQueue = q
+	}
+
+	nextcond = 0
+
+	return OK
+}
+
+// CondCreate function allocates a condition variable and returns its ID
+func CondCreate() (Cid32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	for i := 0; i < NCOND; i++ {
+		cv := nextcond
+
+		nextcond++
+		if int(nextcond) >= NCOND {
+			nextcond = 0
+		}
+
+		if CondTab[cv].CState == CFree {
+			CondTab[cv].CState = CUsed
+			return cv, OK
+		}
+	}
+
+	return NoneCond, ErrSYSERR
+}
+
+// CondDelete function deletes a condition variable and releases the waiting processes
+func CondDelete(cv Cid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadCond(cv) {
+		return ErrSYSERR
+	}
+
+	cptr := &CondTab[cv]
+	cptr.CState = CFree
+
+	ReschedCntl(DeferStart)
+	for pid, err := GetFirst(cptr.CQueue); err == OK; pid, err = GetFirst(cptr.CQueue) {
+		WakeReady(pid, WkDeleted)
+	}
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// condwait function does the work of CondWait and CondWaitTime.
+// maxwait is the timeout in milliseconds, negative means no timeout
+func condwait(cv Cid32, m Mid32, maxwait int32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadCond(cv) || IsBadMutex(m) {
+		return ErrSYSERR
+	}
+
+	mptr := &MutexTab[m]
+	if mptr.MOwner != CurrPid { // must hold the mutex to wait
+		return ErrNOTOWNER
+	}
+
+	if maxwait == 0 { // not allowed to wait at all
+		return ErrTIMEOUT
+	}
+
+	prptr := &Proctab[CurrPid]
+	hold := mptr.MHold
+
+	// queue the process and release the mutex before anyone else can run,
+	// so a signal sent right after the mutex is released cannot be lost
+	ReschedCntl(DeferStart)
+	prptr.PrState = PrCond
+	prptr.PrCv = cv
+	prptr.PrWake = WkOK
+	Enqueue(CurrPid, CondTab[cv].CQueue)
+	if maxwait > 0 {
+		SetTimer(maxwait)
+	}
+
+	mptr.MHold = 1 // release even a recursive mutex completely
+	MutexUnlock(m)
+	ReschedCntl(DeferStop)
+
+	if prptr.PrState == PrCond { // nobody else was made ready, give up the CPU now
+		Resched()
+	}
+
+	// signaled, timed out or deleted, lock the mutex again as it was
+	wake := prptr.PrWake
+	if err := MutexLock(m); err != OK {
+		return err
+	}
+	mptr.MHold = hold
+
+	return WakeErr(wake)
+}
+
+// CondWait function atomically releases mutex m, which the caller must hold,
+// and waits on the condition variable. The mutex is held again on return
+func CondWait(cv Cid32, m Mid32) error {
+	return condwait(cv, m, -1)
+}
+
+// CondWaitTime function is CondWait that gives up after maxwait milliseconds
+// with ErrTIMEOUT. The mutex is held again on return in either case
+func CondWaitTime(cv Cid32, m Mid32, maxwait int32) error {
+	if maxwait < 0 {
+		return ErrSYSERR
+	}
+	return condwait(cv, m, maxwait)
+}
+
+// CondSignal function releases the first process waiting on the condition variable, if any
+func CondSignal(cv Cid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadCond(cv) {
+		return ErrSYSERR
+	}
+
+	cptr := &CondTab[cv]
+	if NonEmpty(cptr.CQueue) {
+		pid, _ := Dequeue(cptr.CQueue)
+		Ready(pid) // could cause a rescheduling
+	}
+
+	return OK
+}
+
+// CondBroadcast function releases every process waiting on the condition
+// variable under a single deferred rescheduling
+func CondBroadcast(cv Cid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadCond(cv) {
+		return ErrSYSERR
+	}
+
+	cptr := &CondTab[cv]
+
+	ReschedCntl(DeferStart)
+	for NonEmpty(cptr.CQueue) {
+		pid, _ := Dequeue(cptr.CQueue)
+		Ready(pid)
+	}
+	ReschedCntl(DeferStop)
+
+	return OK
+}
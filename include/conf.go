@@ -7,6 +7,18 @@ const (
 	NPROC int = 100
 	// NSEM is the maximum number of semaphores
 	NSEM int = 100
+	// NMUTEX is the maximum number of mutexes, each one uses a semaphore
+	NMUTEX int = 50
+	// NCOND is the maximum number of condition variables
+	NCOND int = 50
+	// NRWLOCK is the maximum number of reader-writer locks
+	NRWLOCK int = 20
+	// NEVENT is the maximum number of event groups
+	NEVENT int = 20
+	// NBARRIER is the maximum number of barriers
+	NBARRIER int = 20
+	// NPAYLOAD is the maximum number of message payloads in flight
+	NPAYLOAD int = 64
 
 	// CONSOLE it the tty type device
 	CONSOLE int16 = 0 
@@ -0,0 +1,287 @@
+/* deadlock.go deadlock detector
+
+The detector builds a wait-for graph from the process table. A blocked
+process has an edge to the resource it waits on (a semaphore, a port, a
+buffer pool or a mutex, found through PrState and PrSem) and the resource
+has an edge to the process holding it (SOwner of a semaphore used as a
+lock, i.e. created with a count of 1, or MOwner of the mutex built on
+it). Other semaphores, e.g. those of ports and buffer pools or those
+signaled by producers, have no single holder, so they have no such edge.
+Since a process waits on at most one resource, every process has at most
+one outgoing edge, and a cycle is found by simply following the owners.
+
+Besides cycles, the detector reports the system as stalled when every
+user process is blocked without any chance to be awakened, e.g. all of
+them wait on semaphores or messages while only the null process runs.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+import (
+	"fmt"
+	"strings"
+)
+
+// what a blocked process is waiting for
+const (
+	DlSem     uint8 = 1  // waiting on a plain semaphore
+	DlPtSend  uint8 = 2  // waiting to send to a full port
+	DlPtRecv  uint8 = 3  // waiting to receive from an empty port
+	DlBuf     uint8 = 4  // waiting for a buffer from a buffer pool
+	DlRecv    uint8 = 5  // waiting for a message
+	DlSusp    uint8 = 6  // suspended
+	DlMutex   uint8 = 7  // waiting to lock a mutex
+	DlCond    uint8 = 8  // waiting on a condition variable
+	DlRwLock  uint8 = 9  // waiting for a reader-writer lock
+	DlEvent   uint8 = 10 // waiting for event bits
+	DlBarrier uint8 = 11 // waiting for other processes at a barrier
+	DlSend    uint8 = 12 // waiting for the receiver of a synchronous send
+	DlSelect  uint8 = 13 // waiting on several ports
+)
+
+// dlNames maps the wait kind to a printable name
+var dlNames = [...]string{
+	DlSem:     "semaphore",
+	DlPtSend:  "port send",
+	DlPtRecv:  "port receive",
+	DlBuf:     "buffer pool",
+	DlRecv:    "message",
+	DlSusp:    "suspended",
+	DlMutex:   "mutex",
+	DlCond:    "condition",
+	DlRwLock:  "rwlock",
+	DlEvent:   "event",
+	DlBarrier: "barrier",
+	DlSend:    "rendezvous",
+	DlSelect:  "port select",
+}
+
+// DlWait struct describes what a blocked process is waiting for
+type DlWait struct {
+	DwPid   Pid32 // the blocked process
+	DwKind  uint8 // what the process waits for: DlSem, DlPtSend, ...
+	DwRes   int32 // semaphore id, port id or buffer pool id, -1 if none
+	DwSem   Sid32 // semaphore the process waits on, NoneSem if none
+	DwOwner Pid32 // process holding the resource, NonePid if unknown
+}
+
+// DlReport struct is the result of a deadlock check
+type DlReport struct {
+	DrCycle   bool     // true if processes wait for each other in a cycle
+	DrStalled bool     // true if every user process is blocked forever
+	DrProcs   []DlWait // processes taking part in the deadlock
+}
+
+// String method formats the report for printing
+func (r DlReport) String() string {
+	var b strings.Builder
+
+	switch {
+	case r.DrCycle:
+		b.WriteString("deadlock: wait-for cycle\n")
+	case r.DrStalled:
+		b.WriteString("deadlock: all user processes blocked forever\n")
+	default:
+		return "no deadlock\n"
+	}
+
+	for _, w := range r.DrProcs {
+		fmt.Fprintf(&b, "  pid %d (%s) waits for %s", w.DwPid, procName(w.DwPid), dlNames[w.DwKind])
+		if w.DwRes >= 0 {
+			fmt.Fprintf(&b, " %d", w.DwRes)
+		}
+		if w.DwSem != NoneSem {
+			fmt.Fprintf(&b, " (sem %d)", w.DwSem)
+		}
+		if w.DwOwner != NonePid {
+			fmt.Fprintf(&b, " held by pid %d", w.DwOwner)
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// DlckInterval is how often, in milliseconds, the clock handler runs the
+// deadlock check. Zero means the check only runs on demand
+var DlckInterval uint32 = 0
+
+// DlckHook is called by the clock handler when a deadlock is found
+var DlckHook func(DlReport) = func(r DlReport) {
+	Kprintf(KlError, KsSched, "%s", r.String())
+}
+
+// dlcktick counts the milliseconds since the last periodic check
+var dlcktick uint32
+
+// dlWaitOf function returns what the blocked process pid is waiting for.
+// The second return value is false if pid is not blocked forever.
+func dlWaitOf(pid Pid32) (DlWait, bool) {
+	prptr := &Proctab[pid]
+	w := DlWait{DwPid: pid, DwRes: -1, DwSem: NoneSem, DwOwner: NonePid}
+
+	if prptr.PrTimed { // a timed wait ends by itself
+		return w, false
+	}
+
+	switch prptr.PrState {
+	case PrRecv:
+		w.DwKind = DlRecv
+	case PrSusp:
+		w.DwKind = DlSusp
+	case PrCond:
+		w.DwKind = DlCond
+		w.DwRes = int32(prptr.PrCv)
+	case PrRwWait:
+		// only a writer is a single holder, readers can be many
+		w.DwKind = DlRwLock
+		w.DwRes = int32(prptr.PrRw)
+		w.DwOwner = RwTab[prptr.PrRw].RwWriter
+	case PrEvent:
+		w.DwKind = DlEvent
+		w.DwRes = int32(prptr.PrEv)
+	case PrBarrier:
+		w.DwKind = DlBarrier
+		w.DwRes = int32(prptr.PrBar)
+	case PrSend:
+		// only the receiver can release the sender
+		w.DwKind = DlSend
+		w.DwRes = int32(prptr.PrSendTo)
+		w.DwOwner = prptr.PrSendTo
+	case PrSelect:
+		// any port change can release it, there is no single owner
+		w.DwKind = DlSelect
+	case PrWait:
+		sem := prptr.PrSem
+		w.DwKind = DlSem
+		w.DwRes = int32(sem)
+		w.DwSem = sem
+		if SemTab[sem].SLock { // only a semaphore used as a lock has a holder
+			w.DwOwner = SemTab[sem].SOwner
+		}
+
+		// the semaphore may belong to a port, a buffer pool or a mutex.
+		// SOwner of a counting semaphore is only its last taker, often
+		// the waiter itself, so it is no owner
+		for i := 0; i < MaxPorts; i++ {
+			ptptr := &PortTab[i]
+			if ptptr.PtState != PtStateAlloc {
+				continue
+			}
+			if ptptr.PtSsem == sem {
+				w.DwKind, w.DwRes, w.DwOwner = DlPtSend, int32(i), NonePid
+			} else if ptptr.PtRsem == sem {
+				w.DwKind, w.DwRes, w.DwOwner = DlPtRecv, int32(i), NonePid
+			}
+		}
+		for i := Bpid32(0); i < nbpools; i++ {
+			if BuffPoolTab[i].BpSem == sem {
+				w.DwKind, w.DwRes, w.DwOwner = DlBuf, int32(i), NonePid
+			}
+		}
+		for i := 0; i < NMUTEX; i++ {
+			mptr := &MutexTab[i]
+			if mptr.MState == MUsed && mptr.MSem == sem {
+				// a mutex knows its owner for sure
+				w.DwKind, w.DwRes, w.DwOwner = DlMutex, int32(i), mptr.MOwner
+			}
+		}
+	default:
+		// running, ready or going to wake up by the clock
+		return w, false
+	}
+
+	return w, true
+}
+
+// DeadlockCheck function searches the wait-for graph for cycles and checks
+// whether all user processes are blocked forever. The second return value
+// is true if a deadlock is found, the report then lists the processes
+// taking part in it and the resources they wait on.
+func DeadlockCheck() (DlReport, bool) {
+	mask := Disable()
+	defer Restore(mask)
+
+	var report DlReport
+	var waits [NPROC]DlWait
+	var blocked [NPROC]bool
+
+	nuser, nblocked := 0, 0
+	for pid := Pid32(0); int(pid) < NPROC && int(pid) < len(Proctab); pid++ {
+		if pid == Pid32(NULLProc) || Proctab[pid].PrState == PrFree {
+			continue
+		}
+		nuser++
+		waits[pid], blocked[pid] = dlWaitOf(pid)
+		if blocked[pid] {
+			nblocked++
+		}
+	}
+
+	// follow the owner of the awaited resource from every blocked process.
+	// onpath records the start process of the walk that visited the node
+	var onpath [NPROC]Pid32
+	var incycle [NPROC]bool
+	for i := range onpath {
+		onpath[i] = NonePid
+	}
+
+	for start := Pid32(0); int(start) < NPROC; start++ {
+		if !blocked[start] || onpath[start] != NonePid {
+			continue
+		}
+
+		pid := start
+		for !IsBadPid(pid) && blocked[pid] && onpath[pid] == NonePid {
+			onpath[pid] = start
+			pid = waits[pid].DwOwner
+		}
+
+		// reached a node visited during this very walk: a cycle
+		if !IsBadPid(pid) && blocked[pid] && onpath[pid] == start && !incycle[pid] {
+			for !incycle[pid] {
+				incycle[pid] = true
+				report.DrProcs = append(report.DrProcs, waits[pid])
+				pid = waits[pid].DwOwner
+			}
+			report.DrCycle = true
+		}
+	}
+
+	if report.DrCycle {
+		return report, true
+	}
+
+	if nuser > 0 && nblocked == nuser {
+		report.DrStalled = true
+		for pid := 0; pid < NPROC; pid++ {
+			if blocked[pid] {
+				report.DrProcs = append(report.DrProcs, waits[pid])
+			}
+		}
+		return report, true
+	}
+
+	return report, false
+}
+
+// DeadlockTick function is called by the clock handler every millisecond
+// and runs the deadlock check every DlckInterval milliseconds
+func DeadlockTick() {
+	if DlckInterval == 0 {
+		return
+	}
+
+	dlcktick++
+	if dlcktick < DlckInterval {
+		return
+	}
+	dlcktick = 0
+
+	if report, found := DeadlockCheck(); found && DlckHook != nil {
+		DlckHook(report)
+	}
+}
@@ -0,0 +1,135 @@
+package include
+
+import (
+	"io"
+	"testing"
+	"unsafe"
+)
+
+// dlheap is the memory handed to GetMem by the tests
+var dlheap [1 << 12]uint64
+
+// dlsetup function resets the kernel tables the deadlock tests use and
+// makes pid 1 the current process, with the null process ready
+func dlsetup() {
+	KlogSetSink(io.Discard)
+	PanicReset()
+
+	nextqid = Qid16(NPROC)
+	for i := range Queuetab {
+		Queuetab[i] = Qentry{Qnext: EMPTY, Qprev: EMPTY}
+	}
+	ReadyList, _ = NewQueue()
+	sleepq, _ = NewQueue()
+
+	Proctab = make([]ProcEnt, NPROC)
+	SemTab = make([]SEntry, NSEM)
+	for i := range SemTab {
+		SemTab[i].SQueue, _ = NewQueue()
+	}
+	SendInit()
+	def = Defer{}
+
+	base := unsafe.Pointer(&dlheap[0])
+	blk := (*MemBlk)(base)
+	blk.MNext = nil
+	blk.MLength = uint32(len(dlheap) * 8)
+	freememlist.MNext = blk
+	freememlist.MLength = blk.MLength
+	minheap = base
+	maxheap = unsafe.Pointer(uintptr(base) + uintptr(len(dlheap)*8) - 1)
+
+	Proctab[NULLProc].PrState = PrReady
+	Insert(Pid32(NULLProc), ReadyList, 0)
+
+	Proctab[1].PrState = PrCurr
+	Proctab[1].PrPrio = 20
+	Proctab[1].PrSem = NoneSem
+	CurrPid = 1
+}
+
+// A consumer blocked on an empty port after receiving from it was the last
+// process to take the receive semaphore, but it does not hold the port
+func TestDeadlockPortWaiterNotOwner(t *testing.T) {
+	dlsetup()
+	if err := PtInit(8); err != OK {
+		t.Fatal("PtInit:", err)
+	}
+	portid, err := PtCreate(2)
+	if err != OK {
+		t.Fatal("PtCreate:", err)
+	}
+
+	// the producer is ready to run, at a lower priority
+	Proctab[2].PrState = PrReady
+	Proctab[2].PrPrio = 10
+	Proctab[2].PrSem = NoneSem
+	Insert(2, ReadyList, 10)
+
+	PtSend(portid, 7)
+	if msg, _ := PtRecv(portid); msg != 7 {
+		t.Fatal("received", msg)
+	}
+	// block as PtRecv does on the empty port: ctxsw does not switch
+	// stacks here, so Wait returns as soon as the producer is current
+	Wait(PortTab[portid].PtRsem)
+
+	if Proctab[1].PrState != PrWait || CurrPid != 2 {
+		t.Fatalf("consumer state %d, current %d", Proctab[1].PrState, CurrPid)
+	}
+
+	w, blocked := dlWaitOf(1)
+	if !blocked || w.DwKind != DlPtRecv || w.DwRes != portid || w.DwOwner != NonePid {
+		t.Fatalf("wait %+v", w)
+	}
+
+	if r, found := DeadlockCheck(); found {
+		t.Fatalf("false deadlock %+v", r)
+	}
+}
+
+// dlswitch function makes pid the current process without scheduling
+func dlswitch(pid Pid32) {
+	Proctab[CurrPid].PrState = PrReady
+	Proctab[pid].PrState = PrCurr
+	CurrPid = pid
+}
+
+// The last taker of a semaphore signaled by a producer does not hold it,
+// so waiting on it closes no cycle with the taker
+func TestDeadlockCountingSemNotOwner(t *testing.T) {
+	dlsetup()
+	items, _ := SemCreate(0)
+	lock, _ := SemCreate(1)
+
+	for pid := Pid32(2); pid <= 3; pid++ {
+		Proctab[pid].PrState = PrReady
+		Proctab[pid].PrPrio = 10
+		Proctab[pid].PrSem = NoneSem
+	}
+
+	// pid 1 consumes an item, pid 2 takes the lock
+	Signal(items)
+	Wait(items)
+	dlswitch(2)
+	Wait(lock)
+
+	// pid 1 waits for the lock held by pid 2, which waits for an item
+	// the producer pid 3 has yet to make
+	dlswitch(1)
+	Insert(3, ReadyList, 10)
+	Wait(lock)
+	dlswitch(2)
+	Insert(3, ReadyList, 10)
+	Wait(items)
+
+	if w, _ := dlWaitOf(1); w.DwOwner != 2 {
+		t.Fatalf("lock wait %+v", w)
+	}
+	if w, _ := dlWaitOf(2); w.DwOwner != NonePid {
+		t.Fatalf("item wait %+v", w)
+	}
+	if r, found := DeadlockCheck(); found {
+		t.Fatalf("false deadlock %+v", r)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+event.go event flag groups
+
+An event group holds a 32-bit mask of event flags. Processes set and
+clear flags, and wait until any or all of the flags they are interested
+in are set. A waiting process blocks, in the PrEvent state, on the queue
+of the group in Queuetab like Wait() does on a semaphore queue, and every
+EvSet() releases the waiting processes whose condition has become true.
+With the EvClear option the flags that satisfied a wait are cleared when
+the wait returns, so one EvSet() is consumed by one waiter.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+// EvFree state: event group table entry is available
+const EvFree uint8 = 0
+
+// EvUsed state: event group table entry is used
+const EvUsed uint8 = 1
+
+// wait options, can be combined
+const (
+	EvAny   uint8 = 0 // wait until any of the flags is set
+	EvAll   uint8 = 1 // wait until all of the flags are set
+	EvClear uint8 = 2 // clear the flags that satisfied the wait
+)
+
+// EvEntry struct is the event group table entry
+type EvEntry struct {
+	EvState uint8  // EvFree or EvUsed
+	EvFlags uint32 // the event flags currently set
+	EvQueue Qid16  // queue id of processes that are waiting for flags
+}
+
+// EvTab is the event group table
+var EvTab [NEVENT]EvEntry
+
+// nextev is the next event group index to try to allocate
+var nextev Evid32 = 0
+
+// IsBadEvent function checks if event group id is bad
+func IsBadEvent(ev Evid32) bool {
+	return ev < 0 || int(ev) >= NEVENT || EvTab[ev].EvState == EvFree
+}
+
+// EvInit function initialize the event group table and allocate the
+// waiting queue of every entry
+func EvInit() error {
+	for i := 0; i < NEVENT; i++ {
+		EvTab[i].EvState = EvFree
+
+		q, err := NewQueue()
+		if err != OK {
+			return err
+		}
+		EvTab[i].EvQueue = q
+	}
+
+	nextev = 0
+
+	return OK
+}
+
+// EvCreate function allocates an event group with the given flags set
+func EvCreate(flags uint32) (Evid32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	for i := 0; i < NEVENT; i++ {
+		ev := nextev
+
+		nextev++
+		if int(nextev) >= NEVENT {
+			nextev = 0
+		}
+
+		evptr := &EvTab[ev]
+		if evptr.EvState == EvFree {
+			evptr.EvState = EvUsed
+			evptr.EvFlags = flags
+			return ev, OK
+		}
+	}
+
+	return NoneEvent, ErrSYSERR
+}
+
+// EvDelete function deletes an event group and releases the waiting processes
+func EvDelete(ev Evid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadEvent(ev) {
+		return ErrSYSERR
+	}
+
+	evptr := &EvTab[ev]
+	evptr.EvState = EvFree
+
+	ReschedCntl(DeferStart)
+	for pid, err := GetFirst(evptr.EvQueue); err == OK; pid, err = GetFirst(evptr.EvQueue) {
+		Proctab[pid].PrEvGot = 0
+		WakeReady(pid, WkDeleted)
+	}
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// evmatch function returns the flags that satisfy a wait for want with
+// opts, zero if the wait is not satisfied by flags
+func evmatch(flags uint32, want uint32, opts uint8) uint32 {
+	got := flags & want
+	if opts&EvAll != 0 && got != want {
+		return 0
+	}
+	return got
+}
+
+// EvSet function sets flags of an event group and releases, in the order
+// they arrived, the waiting processes whose condition becomes true
+func EvSet(ev Evid32, flags uint32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadEvent(ev) {
+		return ErrSYSERR
+	}
+
+	evptr := &EvTab[ev]
+	evptr.EvFlags |= flags
+
+	ReschedCntl(DeferStart)
+	q := evptr.EvQueue
+	for curr := FirstID(q); curr != QueueTail(q); {
+		pid := Pid32(curr)
+		curr = Queuetab[curr].Qnext // GetItem() below unlinks pid
+
+		prptr := &Proctab[pid]
+		got := evmatch(evptr.EvFlags, prptr.PrEvWant, prptr.PrEvOpts)
+		if got == 0 {
+			continue
+		}
+
+		// flags consumed by this waiter are not seen by the ones behind it
+		if prptr.PrEvOpts&EvClear != 0 {
+			evptr.EvFlags &^= got
+		}
+
+		prptr.PrEvGot = got
+		GetItem(pid)
+		Ready(pid)
+	}
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// EvClearFlags function clears flags of an event group
+func EvClearFlags(ev Evid32, flags uint32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadEvent(ev) {
+		return ErrSYSERR
+	}
+
+	EvTab[ev].EvFlags &^= flags
+
+	return OK
+}
+
+// EvGet function returns the flags currently set in an event group
+func EvGet(ev Evid32) (uint32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadEvent(ev) {
+		return 0, ErrSYSERR
+	}
+
+	return EvTab[ev].EvFlags, OK
+}
+
+// evwait function does the work of EvWait and EvWaitTime.
+// maxwait is the timeout in milliseconds, negative means no timeout
+func evwait(ev Evid32, want uint32, opts uint8, maxwait int32) (uint32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadEvent(ev) || want == 0 {
+		return 0, ErrSYSERR
+	}
+
+	evptr := &EvTab[ev]
+	if got := evmatch(evptr.EvFlags, want, opts); got != 0 {
+		if opts&EvClear != 0 {
+			evptr.EvFlags &^= got
+		}
+		return got, OK
+	}
+
+	if maxwait == 0 { // not allowed to wait at all
+		return 0, ErrTIMEOUT
+	}
+
+	prptr := &Proctab[CurrPid]
+	prptr.PrState = PrEvent
+	prptr.PrEv = ev
+	prptr.PrEvWant = want
+	prptr.PrEvOpts = opts
+	prptr.PrEvGot = 0
+	prptr.PrWake = WkOK
+
+	Enqueue(CurrPid, evptr.EvQueue)
+	if maxwait > 0 {
+		SetTimer(maxwait)
+	}
+	Resched()
+
+	// EvSet() has recorded the flags that satisfied us, unless timed out or deleted
+	if prptr.PrWake != WkOK {
+		return 0, WakeErr(prptr.PrWake)
+	}
+
+	return prptr.PrEvGot, OK
+}
+
+// EvWait function waits until any (EvAny) or all (EvAll) of the flags in
+// want are set in an event group, and returns the flags that satisfied the
+// wait. With EvClear in opts, those flags are cleared from the group
+func EvWait(ev Evid32, want uint32, opts uint8) (uint32, error) {
+	return evwait(ev, want, opts, -1)
+}
+
+// EvWaitTime function is EvWait that gives up after maxwait milliseconds with ErrTIMEOUT
+func EvWaitTime(ev Evid32, want uint32, opts uint8, maxwait int32) (uint32, error) {
+	if maxwait < 0 {
+		return 0, ErrSYSERR
+	}
+	return evwait(ev, want, opts, maxwait)
+}
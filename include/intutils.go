@@ -7,17 +7,15 @@ intutils.c
 
 package include
 
-import "fmt"
-
 // Restore function restore(roll back) the interrupte state to im
 func Restore(im IntMask) {
-	fmt.Printf("restore interrupt mask to %v\n", im)
+	Kprintf(KlDebug, KsIntr, "restore interrupt mask to %v", im)
 }
 
 // Disable function disable interrupt and return the previous state
 func Disable() IntMask {
 	var oldIm IntMask = 0 // fake interrupt mask
-	fmt.Printf("disable interrupt, previous mask is %v\n", oldIm)
+	Kprintf(KlDebug, KsIntr, "disable interrupt, previous mask is %v", oldIm)
 
 	return oldIm
 }
\ No newline at end of file
@@ -27,6 +27,21 @@ type IntMask uint32
 // Bpid32 is the buffer pool id type
 type Bpid32 int32
 
+// Mid32 is the mutex id
+type Mid32 int32
+
+// Cid32 is the condition variable id
+type Cid32 int32
+
+// Rwid32 is the reader-writer lock id
+type Rwid32 int32
+
+// Evid32 is the event group id
+type Evid32 int32
+
+// Brid32 is the barrier id
+type Brid32 int32
+
 // NonePid represent the universal invalid process id
 const NonePid Pid32 = -1
 
@@ -48,6 +63,21 @@ const NoneMsg Umsg32 = math.MaxUint32
 // NoneBpid represent the universal invalid buffer pool id
 const NoneBpid Bpid32 = -1
 
+// NoneMutex represent the universal invalid mutex id
+const NoneMutex Mid32 = -1
+
+// NoneCond represent the universal invalid condition variable id
+const NoneCond Cid32 = -1
+
+// NoneRwLock represent the universal invalid reader-writer lock id
+const NoneRwLock Rwid32 = -1
+
+// NoneEvent represent the universal invalid event group id
+const NoneEvent Evid32 = -1
+
+// NoneBarrier represent the universal invalid barrier id
+const NoneBarrier Brid32 = -1
+
 // None is the null address value
 const None uintptr = 0
 
@@ -72,4 +102,16 @@ var (
 	ErrTIMEOUT error = fmt.Errorf("TIMEOUT")
 	// ErrEMPTY is the error that caused by invalid operation on empty queue
 	ErrEMPTY error = fmt.Errorf("EMPTY")
+	// ErrNOTOWNER is the error of releasing a lock held by another process
+	ErrNOTOWNER error = fmt.Errorf("NOTOWNER")
+	// ErrDEADLOCK is the error of waiting for a lock the caller already holds
+	ErrDEADLOCK error = fmt.Errorf("DEADLOCK")
+	// ErrWOULDBLOCK is the error of a non-blocking call that would have to wait
+	ErrWOULDBLOCK error = fmt.Errorf("WOULDBLOCK")
+	// ErrDELETED is the error of a wait ended by the deletion of what was waited on
+	ErrDELETED error = fmt.Errorf("DELETED")
+	// ErrRESET is the error of a wait ended by the reset of what was waited on
+	ErrRESET error = fmt.Errorf("RESET")
+	// ErrTRUNC is the error of data cut to fit in the space given by the caller
+	ErrTRUNC error = fmt.Errorf("TRUNC")
 )
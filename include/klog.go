@@ -0,0 +1,183 @@
+/* klog.go kernel logging facility
+
+Kprintf formats a kernel diagnostic message, tags it with a level and the
+subsystem that produced it, and writes it to the configured sink when the
+level and subsystem pass the filters. The sink can be the standard output,
+the console device, an in-memory ring buffer (dmesg) or a file, so tests
+can run silently and still inspect what the kernel said.
+
+files combined from the original X86 version include:
+kprintf.c
+
+*/
+
+package include
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// log levels, in increasing severity
+const (
+	KlDebug uint8 = 0 // verbose tracing of kernel internals
+	KlInfo  uint8 = 1 // normal but noteworthy events
+	KlWarn  uint8 = 2 // something unexpected the kernel can live with
+	KlError uint8 = 3 // something went wrong
+)
+
+// subsystem tags, used as a bit mask by KlogMask
+const (
+	KsSched   uint16 = 1 << 0 // process scheduling and management
+	KsSem     uint16 = 1 << 1 // semaphores
+	KsMem     uint16 = 1 << 2 // memory management
+	KsPort    uint16 = 1 << 3 // ports
+	KsClock   uint16 = 1 << 4 // clock and timed delay
+	KsBufPool uint16 = 1 << 5 // buffer pools
+	KsIntr    uint16 = 1 << 6 // interrupt masking
+
+	// KsAll selects every subsystem
+	KsAll uint16 = 0xFFFF
+)
+
+// DmesgSize is the number of lines kept by the dmesg ring buffer
+const DmesgSize int = 256
+
+// KlogLevel is the minimum level of messages to be written
+var KlogLevel uint8 = KlInfo
+
+// KlogMask selects the subsystems whose messages are written
+var KlogMask uint16 = KsAll
+
+// Console is the writer of the CONSOLE device used by the console sink.
+// There is no device layer yet, so it stands for the terminal of the simulation
+var Console io.Writer = os.Stdout
+
+var (
+	// klogsink is where the messages are written to
+	klogsink io.Writer = os.Stdout
+	// klogfile is the file opened by KlogFile, closed when the sink changes
+	klogfile *os.File
+)
+
+var klevelNames = [...]string{
+	KlDebug: "debug",
+	KlInfo:  "info",
+	KlWarn:  "warn",
+	KlError: "error",
+}
+
+// subsysName function returns the tag printed for a subsystem
+func subsysName(subsys uint16) string {
+	switch subsys {
+	case KsSched:
+		return "sched"
+	case KsSem:
+		return "sem"
+	case KsMem:
+		return "mem"
+	case KsPort:
+		return "port"
+	case KsClock:
+		return "clock"
+	case KsBufPool:
+		return "bufpool"
+	case KsIntr:
+		return "intr"
+	}
+	return "kernel"
+}
+
+// Kprintf function writes a formatted kernel message of the given level and subsystem.
+// It never disables interrupts, so it can be called from anywhere in the kernel,
+// including Disable() and Restore() themselves.
+func Kprintf(level uint8, subsys uint16, format string, args ...interface{}) {
+	if level < KlogLevel || subsys&KlogMask == 0 || klogsink == nil {
+		return
+	}
+
+	lname := "unknown"
+	if int(level) < len(klevelNames) {
+		lname = klevelNames[level]
+	}
+
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	fmt.Fprintf(klogsink, "[%5d.%03d] %s %s: %s\n", clktime, count1000, lname, subsysName(subsys), msg)
+}
+
+// KlogSetSink function makes w the sink of kernel messages. A nil w discards them
+func KlogSetSink(w io.Writer) {
+	if klogfile != nil {
+		klogfile.Close()
+		klogfile = nil
+	}
+	klogsink = w
+}
+
+// KlogStdout function writes kernel messages to the standard output
+func KlogStdout() {
+	KlogSetSink(os.Stdout)
+}
+
+// KlogConsole function writes kernel messages to the console device
+func KlogConsole() {
+	KlogSetSink(Console)
+}
+
+// KlogFile function appends kernel messages to the file at path
+func KlogFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return ErrSYSERR
+	}
+
+	KlogSetSink(f)
+	klogfile = f
+
+	return OK
+}
+
+// dmesgBuf struct is a ring buffer of the latest kernel messages
+type dmesgBuf struct {
+	lines [DmesgSize]string
+	next  int // index to write the next line
+	count int // number of valid lines
+}
+
+// Write method stores every line of p into the ring, dropping the oldest lines
+func (d *dmesgBuf) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		d.lines[d.next] = line
+		d.next = (d.next + 1) % DmesgSize
+		if d.count < DmesgSize {
+			d.count++
+		}
+	}
+	return len(p), nil
+}
+
+// dmesg is the in-memory ring buffer sink
+var dmesg dmesgBuf
+
+// KlogDmesg function keeps kernel messages in the in-memory ring buffer
+func KlogDmesg() {
+	KlogSetSink(&dmesg)
+}
+
+// Dmesg function returns the kernel messages kept in the ring buffer, oldest first
+func Dmesg() []string {
+	lines := make([]string, 0, dmesg.count)
+	start := (dmesg.next - dmesg.count + DmesgSize) % DmesgSize
+	for i := 0; i < dmesg.count; i++ {
+		lines = append(lines, dmesg.lines[(start+i)%DmesgSize])
+	}
+	return lines
+}
+
+// DmesgClear function discards the kernel messages kept in the ring buffer
+func DmesgClear() {
+	dmesg.next = 0
+	dmesg.count = 0
+}
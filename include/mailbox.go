@@ -0,0 +1,245 @@
+/*
+mailbox.go process mailboxes
+
+A process holds a single message in PrMsg, so Send() fails while the
+previous message has not been received yet. A mailbox extends it to a
+ring of MbCap messages allocated with GetMem(): Send() appends to the
+ring and Receive(), RecvClr() and RecvTime() pop from it in FIFO order.
+When the mailbox is full, its overflow policy decides what Send() does:
+
+MbReject:     Send() fails with ErrSYSERR, like without a mailbox;
+MbDropOldest: the oldest message is discarded to make room;
+MbBlock:      the sender waits on MbSsem, which counts the free slots,
+              a process sending to itself is rejected instead.
+
+PrHasMsg stays true as long as the mailbox holds a message, so the
+receiving functions block and wake up exactly as before.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+import "unsafe"
+
+// overflow policies of a full mailbox
+const (
+	MbReject     uint8 = 0 // the new message is rejected
+	MbDropOldest uint8 = 1 // the oldest message is dropped
+	MbBlock      uint8 = 2 // the sender waits for a free slot
+)
+
+// MboxEnt struct is a message in a mailbox together with its sender
+type MboxEnt struct {
+	MeMsg  Umsg32 // the message
+	MeFrom Pid32  // the process that sent it
+	MePl   bool   // true if the message is a payload handle
+}
+
+// Mailbox struct is the mailbox of a process, there is none if MbCap is 0
+type Mailbox struct {
+	MbBuf    unsafe.Pointer // ring of MbCap MboxEnt allocated with GetMem()
+	MbCap    uint32         // number of messages the mailbox can hold
+	MbHead   uint32         // index of the oldest message in the ring
+	MbCount  uint32         // number of messages in the mailbox
+	MbPolicy uint8          // MbReject, MbDropOldest or MbBlock
+	MbSsem   Sid32          // free slot semaphore with MbBlock, NoneSem otherwise
+}
+
+// mbslot function returns the address of the i-th message from the oldest one
+func mbslot(mb *Mailbox, i uint32) *MboxEnt {
+	i = (mb.MbHead + i) % mb.MbCap
+	return (*MboxEnt)(unsafe.Pointer(uintptr(mb.MbBuf) + uintptr(i)*unsafe.Sizeof(MboxEnt{})))
+}
+
+// MboxCreate function gives process pid a mailbox of capacity messages with
+// the given overflow policy. A message already sent to it is kept as the
+// first message of the mailbox
+func MboxCreate(pid Pid32, capacity uint32, policy uint8) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadPid(pid) || capacity == 0 || policy > MbBlock {
+		return ErrSYSERR
+	}
+
+	prptr := &Proctab[pid]
+	if prptr.PrState == PrFree || prptr.PrMbox.MbCap != 0 {
+		return ErrSYSERR
+	}
+
+	nbytes := capacity * uint32(unsafe.Sizeof(MboxEnt{}))
+	buf, err := GetMem(nbytes)
+	if err != OK {
+		return err
+	}
+
+	mb := Mailbox{MbBuf: buf, MbCap: capacity, MbPolicy: policy, MbSsem: NoneSem}
+	if prptr.PrHasMsg {
+		*mbslot(&mb, 0) = MboxEnt{prptr.PrMsg, prptr.PrMsgFrom, prptr.PrMsgPl}
+		mb.MbCount = 1
+	}
+
+	if policy == MbBlock {
+		mb.MbSsem, err = SemCreate(int32(capacity - mb.MbCount))
+		if err != OK {
+			FreeMem(buf, nbytes)
+			return err
+		}
+	}
+
+	prptr.PrMbox = mb
+
+	return OK
+}
+
+// MboxDelete function removes the mailbox of process pid. The oldest pending
+// message becomes the single message of the process, the others are lost,
+// and blocked senders return from Send() with ErrDELETED
+func MboxDelete(pid Pid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadPid(pid) {
+		return ErrSYSERR
+	}
+
+	prptr := &Proctab[pid]
+	if prptr.PrState == PrFree || prptr.PrMbox.MbCap == 0 {
+		return ErrSYSERR
+	}
+
+	mb := &prptr.PrMbox
+	if mb.MbCount > 0 {
+		ent := mbslot(mb, 0)
+		prptr.PrMsg, prptr.PrMsgFrom, prptr.PrMsgPl = ent.MeMsg, ent.MeFrom, ent.MePl
+	}
+	for i := uint32(1); i < mb.MbCount; i++ {
+		if ent := mbslot(mb, i); ent.MePl {
+			pldrop(ent.MeMsg)
+		}
+	}
+	mbfree(prptr)
+
+	return OK
+}
+
+// MboxCount function returns the number of messages waiting in the mailbox
+// of process pid and its capacity
+func MboxCount(pid Pid32) (uint32, uint32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadPid(pid) || Proctab[pid].PrState == PrFree || Proctab[pid].PrMbox.MbCap == 0 {
+		return 0, 0, ErrSYSERR
+	}
+
+	mb := &Proctab[pid].PrMbox
+	return mb.MbCount, mb.MbCap, OK
+}
+
+// mbfree function releases the mailbox of a process, if it has one
+// (internal function assumes interrupts disabled)
+func mbfree(prptr *ProcEnt) {
+	mb := &prptr.PrMbox
+	if mb.MbCap == 0 {
+		return
+	}
+
+	FreeMem(mb.MbBuf, mb.MbCap*uint32(unsafe.Sizeof(MboxEnt{})))
+	if mb.MbSsem != NoneSem {
+		SemDelete(mb.MbSsem) // could cause a rescheduling
+	}
+
+	*mb = Mailbox{MbSsem: NoneSem}
+}
+
+// msgput function stores msg sent by process from for process pid, in its
+// mailbox if it has one, pl is true if msg is a payload handle. With block
+// false, a full MbBlock mailbox rejects the message instead of blocking the
+// caller (internal function assumes interrupts disabled)
+func msgput(pid Pid32, msg Umsg32, from Pid32, pl bool, block bool) error {
+	prptr := &Proctab[pid]
+	mb := &prptr.PrMbox
+
+	if mb.MbCap == 0 {
+		if prptr.PrHasMsg {
+			// if there is a previous message to be received, do not overwrite it
+			return ErrSYSERR
+		}
+		prptr.PrMsg = msg
+		prptr.PrMsgFrom = from
+		prptr.PrMsgPl = pl
+		prptr.PrHasMsg = true
+		return OK
+	}
+
+	switch mb.MbPolicy {
+	case MbReject:
+		if mb.MbCount == mb.MbCap {
+			return ErrSYSERR
+		}
+	case MbDropOldest:
+		if mb.MbCount == mb.MbCap {
+			if ent := mbslot(mb, 0); ent.MePl {
+				pldrop(ent.MeMsg)
+			}
+			mb.MbHead = (mb.MbHead + 1) % mb.MbCap
+			mb.MbCount--
+		}
+	case MbBlock:
+		// take a free slot, the receiver gives it back. ErrDELETED if the
+		// mailbox is deleted or the receiver killed while we are waiting.
+		// A process sending to itself would wait for itself forever
+		if !block || pid == CurrPid {
+			if TryWait(mb.MbSsem) != OK {
+				return ErrSYSERR
+			}
+		} else if err := Wait(mb.MbSsem); err != OK {
+			return err
+		}
+	}
+
+	*mbslot(mb, mb.MbCount) = MboxEnt{msg, from, pl}
+	mb.MbCount++
+	prptr.PrHasMsg = true
+
+	return OK
+}
+
+// msgfull function tells if Send() to the process can store no more message
+// until one is received: its single message is taken, or its mailbox is full
+// and does not drop the oldest message (internal function assumes
+// interrupts disabled)
+func msgfull(prptr *ProcEnt) bool {
+	mb := &prptr.PrMbox
+	if mb.MbCap == 0 {
+		return prptr.PrHasMsg
+	}
+	return mb.MbCount == mb.MbCap && mb.MbPolicy != MbDropOldest
+}
+
+// mbtake function removes the i-th message from the oldest one from the
+// mailbox of the process and returns it with its sender, the messages
+// behind it move up (internal function assumes interrupts disabled)
+func mbtake(prptr *ProcEnt, i uint32) (Umsg32, Pid32) {
+	mb := &prptr.PrMbox
+	ent := *mbslot(mb, i)
+
+	if i == 0 {
+		mb.MbHead = (mb.MbHead + 1) % mb.MbCap
+	} else {
+		for ; i+1 < mb.MbCount; i++ {
+			*mbslot(mb, i) = *mbslot(mb, i+1)
+		}
+	}
+	mb.MbCount--
+	prptr.PrHasMsg = mb.MbCount > 0
+
+	if mb.MbPolicy == MbBlock {
+		Signal(mb.MbSsem) // a blocked sender can take the slot
+	}
+
+	return ent.MeMsg, ent.MeFrom
+}
@@ -79,6 +79,7 @@ func GetMem(nbytes uint32) (unsafe.Pointer, error) {
 			// unlink this memory block from the free memory list
 			prev.MNext = curr.MNext
 			freememlist.MLength -= nbytes
+			Trace(TraceAlloc, int32(nbytes), TraceMemHeap)
 
 			return unsafe.Pointer(curr), OK
 
@@ -100,6 +101,7 @@ func GetMem(nbytes uint32) (unsafe.Pointer, error) {
 
 			// update global memory size
 			freememlist.MLength -= nbytes
+			Trace(TraceAlloc, int32(nbytes), TraceMemHeap)
 			return currPointer, OK
 		} else {
 			prev = curr
@@ -159,6 +161,7 @@ func GetStk(nbytes uint32) (unsafe.Pointer, error) {
 	}
 
 	freememlist.MLength -= nbytes
+	Trace(TraceAlloc, int32(nbytes), TraceMemStack)
 
 	// retFits point to the piece from the highest part of the selected block
 	fitsPointer := unsafe.Pointer(fits)
@@ -229,6 +232,7 @@ func FreeMem(blkaddr unsafe.Pointer, nbytes uint32) error {
 	}
 
 	freememlist.MLength += nbytes
+	Trace(TraceFree, int32(nbytes), TraceMemHeap)
 
 	if topPrev == block {// coalesce with previous block, blend into the previous
 		prev.MLength += nbytes
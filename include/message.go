@@ -10,8 +10,21 @@ recvclr.c
 
 package include
 
-// Send function pass a message to process and start recepient if waiting
+// MsgMatch is the predicate of a selective receive, it tells if the message
+// msg sent by process from is accepted
+type MsgMatch func(msg Umsg32, from Pid32) bool
+
+// Send function pass a message to process and start recepient if waiting.
+// If the process has a mailbox, the message is queued according to its
+// overflow policy, see mailbox.go
 func Send(pid Pid32, msg Umsg32) error {
+	return send(pid, msg, CurrPid, false, true)
+}
+
+// send function does the work of Send, recording from as the sender, pl is
+// true if msg is a payload handle. With block false it never waits for
+// room in a mailbox, e.g. when Kill() notifies the parent
+func send(pid Pid32, msg Umsg32, from Pid32, pl bool, block bool) error {
 	mask := Disable()
 	defer Restore(mask)
 
@@ -20,14 +33,18 @@ func Send(pid Pid32, msg Umsg32) error {
 	}
 
 	prptr := &Proctab[pid]
-	if prptr.PrState == PrFree || prptr.PrHasMsg {
-		// if there is a previous message to be received, do not overwrite it
+	if prptr.PrState == PrFree {
 		return ErrSYSERR
 	}
 
 	// save the msg to process pid and notify it by set the PrHasMsg field
-	prptr.PrMsg = msg
-	prptr.PrHasMsg = true
+	if err := msgput(pid, msg, from, pl, block); err != OK {
+		return err
+	}
+	if pl {
+		plown(msg, pid) // a payload now belongs to the receiver
+	}
+	Trace(TraceSend, int32(pid), int32(msg))
 
 	if prptr.PrState == PrRecv {
 		// if process pid is in PrRecv state, make it ready
@@ -42,30 +59,116 @@ func Send(pid Pid32, msg Umsg32) error {
 	return OK
 }
 
-// Receive function wait for message and return the message to the caller
-func Receive() Umsg32 {
+// msgready function tells if a message can be received without waiting,
+// either stored by Send() or carried by a sender waiting in SendSync()
+func msgready(prptr *ProcEnt) bool {
+	return prptr.PrHasMsg || NonEmpty(prptr.PrSendQ)
+}
+
+// msgfind function removes the oldest message accepted by match, nil
+// accepting any message, and returns it with its sender. Messages stored
+// by Send() are looked at before the senders waiting in SendSync()
+// (internal function assumes interrupts disabled)
+func msgfind(prptr *ProcEnt, match MsgMatch) (Umsg32, Pid32, bool) {
+	accept := func(msg Umsg32, from Pid32) bool {
+		return match == nil || match(msg, from)
+	}
+
+	if mb := &prptr.PrMbox; mb.MbCap != 0 {
+		for i := uint32(0); i < mb.MbCount; i++ {
+			if ent := mbslot(mb, i); accept(ent.MeMsg, ent.MeFrom) {
+				msg, from := mbtake(prptr, i)
+				return msg, from, true
+			}
+		}
+	} else if prptr.PrHasMsg && accept(prptr.PrMsg, prptr.PrMsgFrom) {
+		prptr.PrHasMsg = false
+		return prptr.PrMsg, prptr.PrMsgFrom, true
+	}
+
+	q := prptr.PrSendQ
+	for curr := FirstID(q); curr != QueueTail(q); curr = Queuetab[curr].Qnext {
+		pid := Pid32(curr)
+		if accept(Proctab[pid].PrSendMsg, pid) {
+			return sendtake(pid), pid, true
+		}
+	}
+
+	return NoneMsg, NonePid, false
+}
+
+// msgget function removes the oldest message of the process and returns it
+// with its sender, the caller has checked msgready() (internal function
+// assumes interrupts disabled)
+func msgget(prptr *ProcEnt) (Umsg32, Pid32) {
+	msg, from, _ := msgfind(prptr, nil)
+	return msg, from
+}
+
+// recv function does the work of the blocking receive functions, it waits
+// for a message accepted by match and returns it with its sender. With a
+// match it fails with ErrWOULDBLOCK when the messages not accepted leave no
+// room for another one, since Send() could never deliver the awaited message
+func recv(match MsgMatch) (Umsg32, Pid32, error) {
 	mask := Disable()
 	defer Restore(mask)
 
 	prptr := &Proctab[CurrPid]
-	if prptr.PrHasMsg == false {
+	for {
+		// retrieve message and save it on stack
+		msg, from, ok := msgfind(prptr, match)
+		if ok {
+			Trace(TraceRecv, int32(CurrPid), int32(msg))
+
+			// DO NOT: return prptr.PrMsg
+			// because after restoring the interrupt, another interrupt could occur,
+			// and may overwrite the prptr.PrMsg filed since PrHasMsg has been reset.
+			return msg, from, OK
+		}
+
+		if match != nil && msgfull(prptr) {
+			return NoneMsg, NonePid, ErrWOULDBLOCK
+		}
+
 		// no message available now, waiting for it
 		prptr.PrState = PrRecv
 		// give chance to another process to run
 		Resched()
 		// when returned from Resched(), it means another process
-		// must have send message to it by calling Send() function.
+		// must have send message to it, look again if it is accepted
 	}
+}
 
-	msg := prptr.PrMsg     // retrieve message and save it on stack
-	prptr.PrHasMsg = false // reset message nofity flag
-
-	// DO NOT: return prptr.PrMsg
-	// because after restoring the interrupt, another interrupt could occur,
-	// and may overwrite the prptr.PrMsg filed since PrHasMsg has been reset.
+// Receive function wait for message and return the message to the caller
+func Receive() Umsg32 {
+	msg, _, _ := recv(nil)
 	return msg
 }
 
+// ReceiveFrom function is Receive that also returns the process that sent the message
+func ReceiveFrom() (Umsg32, Pid32) {
+	msg, from, _ := recv(nil)
+	return msg, from
+}
+
+// RecvMatch function waits for a message accepted by match and returns it
+// with its sender. The messages not accepted stay queued in arrival order.
+// It fails with ErrWOULDBLOCK instead of waiting forever when they fill the
+// process, i.e. without a mailbox, or with a full mailbox that does not
+// drop the oldest message
+func RecvMatch(match MsgMatch) (Umsg32, Pid32, error) {
+	return recv(match)
+}
+
+// RecvPid function waits for a message sent by process pid, leaving the
+// messages of other senders queued. It fails with ErrWOULDBLOCK like RecvMatch
+func RecvPid(pid Pid32) (Umsg32, error) {
+	msg, _, err := recv(func(_ Umsg32, from Pid32) bool {
+		return from == pid
+	})
+	return msg, err
+}
+
 // RecvClr function clear incoming message and return message if one message is
 // waitting to be retrieved. It not block when there is no message available.
 func RecvClr() (Umsg32, error) {
@@ -73,13 +176,13 @@ func RecvClr() (Umsg32, error) {
 	defer Restore(mask)
 
 	prptr := &Proctab[CurrPid]
-	if prptr.PrHasMsg == false {
+	if !msgready(prptr) {
 		// no rescheduling when no message available
 		return NoneMsg, ErrEMPTY
 	}
 
-	msg := prptr.PrMsg
-	prptr.PrHasMsg = false
+	msg, _ := msgget(prptr)
+	Trace(TraceRecv, int32(CurrPid), int32(msg))
 
 	return msg, OK
 }
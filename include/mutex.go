@@ -0,0 +1,188 @@
+/*
+mutex.go mutual exclusion locks
+
+A mutex is a semaphore created with count 1 plus the process that holds
+it. Processes waiting for the lock block in the semaphore queue (SQueue)
+exactly like Wait() does. Unlike a raw semaphore, only the holder may
+unlock it, and locking a mutex the caller already holds is reported
+instead of deadlocking, or counted when the mutex is recursive. Kill()
+releases the mutexes of the killed process.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+// MFree state: mutex table entry is available
+const MFree uint8 = 0
+
+// MUsed state: mutex table entry is used
+const MUsed uint8 = 1
+
+// MutexEntry struct is the mutex table entry
+type MutexEntry struct {
+	MState     uint8  // MFree or MUsed
+	MSem       Sid32  // semaphore the waiting processes block on
+	MOwner     Pid32  // process holding the lock, NonePid if unlocked
+	MHold      uint32 // times the owner has locked it, above 1 only if recursive
+	MRecursive bool   // true if the owner may lock it again
+}
+
+// MutexTab is the mutex table
+var MutexTab [NMUTEX]MutexEntry
+
+// nextmutex is the next mutex index to try to allocate
+var nextmutex Mid32 = 0
+
+// IsBadMutex function checks if mutex id is bad
+func IsBadMutex(m Mid32) bool {
+	return m < 0 || int(m) >= NMUTEX || MutexTab[m].MState == MFree
+}
+
+// MutexCreate function creates an unlocked mutex and returns its ID.
+// A recursive mutex can be locked again by its owner, and is released
+// when it has been unlocked as many times as it has been locked.
+func MutexCreate(recursive bool) (Mid32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	for i := 0; i < NMUTEX; i++ {
+		m := nextmutex
+
+		nextmutex++
+		if int(nextmutex) >= NMUTEX {
+			nextmutex = 0
+		}
+
+		mptr := &MutexTab[m]
+		if mptr.MState != MFree {
+			continue
+		}
+
+		sem, err := SemCreate(1)
+		if err != OK {
+			return NoneMutex, err
+		}
+
+		mptr.MState = MUsed
+		mptr.MSem = sem
+		mptr.MOwner = NonePid
+		mptr.MHold = 0
+		mptr.MRecursive = recursive
+
+		return m, OK
+	}
+
+	return NoneMutex, ErrSYSERR
+}
+
+// MutexDelete function deletes a mutex, the processes waiting for it are released
+func MutexDelete(m Mid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadMutex(m) {
+		return ErrSYSERR
+	}
+
+	mptr := &MutexTab[m]
+	mptr.MState = MFree
+	mptr.MOwner = NonePid
+	mptr.MHold = 0
+
+	return SemDelete(mptr.MSem)
+}
+
+// MutexLock function acquires a mutex, blocking until it is available
+func MutexLock(m Mid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadMutex(m) {
+		return ErrSYSERR
+	}
+
+	mptr := &MutexTab[m]
+	if mptr.MOwner == CurrPid {
+		if !mptr.MRecursive {
+			// waiting would block the caller forever
+			return ErrDEADLOCK
+		}
+		mptr.MHold++
+		return OK
+	}
+
+	// ErrDELETED if the mutex is deleted while we are waiting
+	if err := Wait(mptr.MSem); err != OK {
+		return err
+	}
+
+	mptr.MOwner = CurrPid
+	mptr.MHold = 1
+
+	return OK
+}
+
+// MutexUnlock function releases a mutex held by the calling process
+func MutexUnlock(m Mid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadMutex(m) {
+		return ErrSYSERR
+	}
+
+	mptr := &MutexTab[m]
+	if mptr.MOwner != CurrPid {
+		return ErrNOTOWNER
+	}
+
+	mptr.MHold--
+	if mptr.MHold > 0 { // recursive mutex still held
+		return OK
+	}
+
+	return mxrelease(mptr)
+}
+
+// mxrelease function hands a mutex over to the first waiting process, if
+// any, so the owner is right even before that process runs again, otherwise
+// unlocks it (internal function assumes interrupts disabled)
+func mxrelease(mptr *MutexEntry) error {
+	semptr := &SemTab[mptr.MSem]
+	mptr.MOwner = NonePid
+	mptr.MHold = 0
+	if semptr.SCount < 0 {
+		mptr.MOwner = Pid32(FirstID(semptr.SQueue))
+		mptr.MHold = 1
+	}
+
+	return Signal(mptr.MSem) // could cause a rescheduling
+}
+
+// MutexOwner function returns the process holding a mutex and how many
+// times it has locked it. The owner is NonePid if the mutex is unlocked
+func MutexOwner(m Mid32) (Pid32, uint32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadMutex(m) {
+		return NonePid, 0, ErrSYSERR
+	}
+
+	mptr := &MutexTab[m]
+	return mptr.MOwner, mptr.MHold, OK
+}
+
+// mxkill function releases the mutexes held by process pid when it is
+// killed, including one handed over to it before it could run again
+// (internal function assumes interrupts disabled)
+func mxkill(pid Pid32) {
+	for i := 0; i < NMUTEX; i++ {
+		mptr := &MutexTab[i]
+		if mptr.MState == MUsed && mptr.MOwner == pid {
+			mxrelease(mptr) // could cause a rescheduling
+		}
+	}
+}
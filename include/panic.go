@@ -0,0 +1,138 @@
+/* panic.go kernel panic
+
+Panic is called when the kernel finds itself in a state that should be
+impossible. It disables interrupts, stops any further rescheduling,
+writes a dump of the kernel state through Kprintf and then stops the
+simulation by a Go panic whose value is a *KernelPanic, so tests can
+recover() it and assert on the message and the dump.
+
+files combined from the original X86 version include:
+panic.c
+
+*/
+
+package include
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PanicTraceN is the number of latest trace events included in the dump
+const PanicTraceN int = 32
+
+// KernelPanic struct is the value the simulation stops with after Panic
+type KernelPanic struct {
+	Msg  string // why the kernel panicked
+	Dump string // state of the kernel when it panicked
+}
+
+// Error method makes KernelPanic an error
+func (p *KernelPanic) Error() string {
+	return "panic: " + p.Msg
+}
+
+// kpanic is non-nil once the kernel has panicked
+var kpanic *KernelPanic
+
+// Panicked function returns the panic the kernel stopped with, nil if none
+func Panicked() *KernelPanic {
+	return kpanic
+}
+
+// PanicReset function forgets a previous panic so the kernel can be set up
+// again, e.g. by the next test
+func PanicReset() {
+	kpanic = nil
+}
+
+// Panic function halts the kernel, dumps its state and stops the simulation
+func Panic(msg string) {
+	Disable() // never restored
+
+	if kpanic != nil { // panic while panicking, e.g. in the dump itself
+		panic(kpanic)
+	}
+
+	// halt scheduling first, the dump must not cause a context switch
+	kpanic = &KernelPanic{Msg: msg}
+	kpanic.Dump = PanicDump()
+
+	Kprintf(KlError, KsSched, "panic: %s\n%s", msg, kpanic.Dump)
+
+	panic(kpanic)
+}
+
+// PanicDump function formats the current process, the ready and sleep
+// queues, the semaphore and port tables and the latest trace events
+func PanicDump() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "current process: pid %d\n", CurrPid)
+
+	b.WriteString("processes:\n")
+	for pid := 0; pid < len(Proctab); pid++ {
+		prptr := &Proctab[pid]
+		if prptr.PrState == PrFree {
+			continue
+		}
+		fmt.Fprintf(&b, "  pid %3d %-16s %-7s prio %3d sem %3d parent %3d\n",
+			pid, procName(Pid32(pid)), PrStateName(prptr.PrState), prptr.PrPrio, prptr.PrSem, prptr.PrParent)
+	}
+
+	b.WriteString("ready list:")
+	dumpQueue(&b, ReadyList)
+	b.WriteString("sleep queue (delta):")
+	dumpQueue(&b, sleepq)
+
+	b.WriteString("semaphores:\n")
+	for sem := 0; sem < len(SemTab); sem++ {
+		semptr := &SemTab[sem]
+		if semptr.SState == SFree {
+			continue
+		}
+		fmt.Fprintf(&b, "  sem %3d count %4d owner %3d waiting:", sem, semptr.SCount, semptr.SOwner)
+		dumpQueue(&b, semptr.SQueue)
+	}
+
+	b.WriteString("ports:\n")
+	for i := 0; i < MaxPorts; i++ {
+		ptptr := &PortTab[i]
+		if ptptr.PtState != PtStateAlloc && ptptr.PtState != PtStateLimbo {
+			continue
+		}
+		nmsgs := 0
+		for walk := ptptr.PtHead; walk != nil; walk = walk.PtNext {
+			nmsgs++
+		}
+		fmt.Fprintf(&b, "  port %2d state %d seq %d msgs %d/%d ssem %d rsem %d\n",
+			i, ptptr.PtState, ptptr.PtSeq, nmsgs, ptptr.PtMaxCnt, ptptr.PtSsem, ptptr.PtRsem)
+	}
+
+	events := TraceSnapshot()
+	if len(events) > PanicTraceN {
+		events = events[len(events)-PanicTraceN:]
+	}
+	b.WriteString("recent events:\n")
+	for _, ev := range events {
+		fmt.Fprintf(&b, "  %10d us pid %3d %-8s %d %d\n", ev.TrTime, ev.TrPid, TraceName(ev.TrKind), ev.TrArg1, ev.TrArg2)
+	}
+
+	return b.String()
+}
+
+// dumpQueue function writes the nodes of queue q with their keys
+func dumpQueue(b *strings.Builder, q Qid16) {
+	if IsBadQid(q) {
+		b.WriteString(" (bad queue)\n")
+		return
+	}
+
+	// bound the walk in case the queue itself is corrupted
+	n := 0
+	for curr := FirstID(q); curr != QueueTail(q) && curr != EMPTY && n < NPROC; curr = Queuetab[curr].Qnext {
+		fmt.Fprintf(b, " %d(%d)", curr, Queuetab[curr].Qkey)
+		n++
+	}
+	b.WriteString("\n")
+}
@@ -0,0 +1,339 @@
+/*
+payload.go variable-length message payloads
+
+Send() and PtSend() carry a single Umsg32. A payload carries any number
+of bytes up to the buffer size of a buffer pool: the bytes are copied into
+a buffer from GetBuf(), or a buffer already filled by the sender is loaned,
+and the message sent is a handle to the payload table entry recording the
+buffer and the length. SendPayload() and PtSendPayload() copy and send in
+one call, PlSend() and PlPtSend() send a payload made by PlCopy() or
+PlLoan(). The receiver reads the payload with PlRead(), which copies it
+out and frees the buffer, or takes the buffer over with PlTake() and frees
+it later with FreeBuf(). Only the owner of a payload can send, read, take
+or discard it.
+
+Only these functions send payloads, and the message is marked as a
+payload where it is queued (PrMsgPl, MePl, PtPl), so a plain message
+whose value happens to equal a handle is never taken for a payload. A payload is owned by the process that created it, then by the
+process it is sent to. While queued in a port it is owned by nobody.
+Kill() frees the payloads of the process and PtDelete() and PtReset()
+the payloads left in the port, so their buffers are never lost.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+import "unsafe"
+
+// PlFree state: payload table entry is available
+const PlFree uint8 = 0
+
+// PlUsed state: payload table entry is used
+const PlUsed uint8 = 1
+
+// a payload handle is plTag in bits 31-28, the generation of the entry
+// in bits 27-16 and the index of the entry in bits 15-0
+const (
+	plTag     Umsg32 = 0xA0000000
+	plTagMask Umsg32 = 0xF0000000
+)
+
+// PlEntry struct is the payload table entry
+type PlEntry struct {
+	PlState uint8          // PlFree or PlUsed
+	PlGen   uint16         // generation, incremented every time the entry is allocated
+	PlBuf   unsafe.Pointer // buffer from GetBuf() holding the bytes
+	PlLen   uint32         // number of bytes in the buffer
+	PlOwner Pid32          // process owning the payload, NonePid while queued in a port
+}
+
+// PlTab is the payload table
+var PlTab [NPAYLOAD]PlEntry
+
+// nextpl is the next payload index to try to allocate
+var nextpl int32 = 0
+
+// plhandle function returns the message that stands for payload entry i
+func plhandle(i int32) Umsg32 {
+	return plTag | Umsg32(PlTab[i].PlGen&0xFFF)<<16 | Umsg32(i)
+}
+
+// pllookup function returns the payload table index of handle msg,
+// -1 if msg is not the handle of a live payload
+func pllookup(msg Umsg32) int32 {
+	if msg&plTagMask != plTag {
+		return -1
+	}
+
+	i := int32(msg & 0xFFFF)
+	if int(i) >= NPAYLOAD || PlTab[i].PlState == PlFree || plhandle(i) != msg {
+		return -1
+	}
+
+	return i
+}
+
+// plmine function returns the payload table index of handle msg if it is a
+// payload owned by the current process, -1 otherwise
+func plmine(msg Umsg32) int32 {
+	i := pllookup(msg)
+	if i < 0 || PlTab[i].PlOwner != CurrPid {
+		return -1
+	}
+
+	return i
+}
+
+// IsPayload function checks if a received message is the handle of a
+// payload owned by the calling process
+func IsPayload(msg Umsg32) bool {
+	mask := Disable()
+	defer Restore(mask)
+
+	return plmine(msg) >= 0
+}
+
+// bufsize function returns the buffer size of the pool buffer buf comes from, 0 if none
+func bufsize(buf unsafe.Pointer) uint32 {
+	if buf == nil {
+		return 0
+	}
+
+	poolid := *(*Bpid32)(unsafe.Pointer(uintptr(buf) - unsafe.Sizeof(Bpid32(0))))
+	if poolid < 0 || poolid >= nbpools {
+		return 0
+	}
+
+	return BuffPoolTab[poolid].BpSize
+}
+
+// plalloc function allocates a payload entry for length bytes in buf,
+// owned by the current process (internal function assumes interrupts disabled)
+func plalloc(buf unsafe.Pointer, length uint32) (Umsg32, error) {
+	for n := 0; n < NPAYLOAD; n++ {
+		i := nextpl
+
+		nextpl++
+		if int(nextpl) >= NPAYLOAD {
+			nextpl = 0
+		}
+
+		plptr := &PlTab[i]
+		if plptr.PlState == PlFree {
+			plptr.PlState = PlUsed
+			plptr.PlGen++
+			plptr.PlBuf = buf
+			plptr.PlLen = length
+			plptr.PlOwner = CurrPid
+			return plhandle(i), OK
+		}
+	}
+
+	return NoneMsg, ErrEMPTY
+}
+
+// plfree function releases payload entry i, freeing its buffer if dobuf
+// (internal function assumes interrupts disabled)
+func plfree(i int32, dobuf bool) {
+	plptr := &PlTab[i]
+	if dobuf {
+		FreeBuf(plptr.PlBuf)
+	}
+
+	plptr.PlState = PlFree
+	plptr.PlBuf = nil
+	plptr.PlLen = 0
+	plptr.PlOwner = NonePid
+}
+
+// PlCopy function copies data into a buffer of pool poolid and returns the
+// payload handle to send. It may block until the pool has a free buffer
+func PlCopy(poolid Bpid32, data []byte) (Umsg32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if poolid < 0 || poolid >= nbpools || uint32(len(data)) > BuffPoolTab[poolid].BpSize {
+		return NoneMsg, ErrSYSERR
+	}
+
+	buf, err := GetBuf(poolid)
+	if err != OK {
+		return NoneMsg, err
+	}
+	copy(unsafe.Slice((*byte)(buf), len(data)), data)
+
+	h, err := plalloc(buf, uint32(len(data)))
+	if err != OK {
+		FreeBuf(buf)
+	}
+
+	return h, err
+}
+
+// PlLoan function turns length bytes already written in buf, a buffer from
+// GetBuf(), into a payload without copying. The buffer then belongs to
+// the payload and must not be used or freed by the caller anymore
+func PlLoan(buf unsafe.Pointer, length uint32) (Umsg32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if length > bufsize(buf) {
+		return NoneMsg, ErrSYSERR
+	}
+
+	return plalloc(buf, length)
+}
+
+// PlLen function returns the number of bytes of a payload owned by the caller
+func PlLen(msg Umsg32) (uint32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	i := plmine(msg)
+	if i < 0 {
+		return 0, ErrSYSERR
+	}
+
+	return PlTab[i].PlLen, OK
+}
+
+// PlRead function copies a payload into dst and frees it. It returns the
+// number of bytes copied, with ErrTRUNC if dst is too short for the payload,
+// in which case the bytes beyond len(dst) are lost
+func PlRead(msg Umsg32, dst []byte) (uint32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	i := plmine(msg)
+	if i < 0 {
+		return 0, ErrSYSERR
+	}
+
+	plptr := &PlTab[i]
+	n := copy(dst, unsafe.Slice((*byte)(plptr.PlBuf), plptr.PlLen))
+	truncated := uint32(n) < plptr.PlLen
+	plfree(i, true)
+
+	if truncated {
+		return uint32(n), ErrTRUNC
+	}
+
+	return uint32(n), OK
+}
+
+// PlTake function takes the buffer of a payload over without copying. The
+// caller owns the buffer and frees it with FreeBuf()
+func PlTake(msg Umsg32) (unsafe.Pointer, uint32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	i := plmine(msg)
+	if i < 0 {
+		return NonePointer, 0, ErrSYSERR
+	}
+
+	buf, length := PlTab[i].PlBuf, PlTab[i].PlLen
+	plfree(i, false)
+
+	return buf, length, OK
+}
+
+// PlDiscard function frees a payload without reading it
+func PlDiscard(msg Umsg32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	i := plmine(msg)
+	if i < 0 {
+		return ErrSYSERR
+	}
+
+	plfree(i, true)
+
+	return OK
+}
+
+// PlSend function sends the payload h owned by the caller to process pid,
+// which then owns it. The caller keeps the payload if it cannot be sent
+func PlSend(pid Pid32, h Umsg32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if plmine(h) < 0 {
+		return ErrSYSERR
+	}
+
+	return send(pid, h, CurrPid, true, true)
+}
+
+// PlPtSend function sends the payload h owned by the caller to a port,
+// blocking if the port is full. The caller keeps the payload if it cannot be sent
+func PlPtSend(portid int32, h Umsg32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if plmine(h) < 0 {
+		return ErrSYSERR
+	}
+
+	return ptsend(portid, h, PtPrioNormal, true, -1)
+}
+
+// SendPayload function sends a copy of data to process pid as a payload.
+// The payload is freed if it cannot be sent
+func SendPayload(pid Pid32, poolid Bpid32, data []byte) error {
+	h, err := PlCopy(poolid, data)
+	if err != OK {
+		return err
+	}
+
+	if err = PlSend(pid, h); err != OK {
+		PlDiscard(h)
+	}
+
+	return err
+}
+
+// PtSendPayload function sends a copy of data to a port as a payload,
+// blocking if the port is full. The payload is freed if it cannot be sent
+func PtSendPayload(portid int32, poolid Bpid32, data []byte) error {
+	h, err := PlCopy(poolid, data)
+	if err != OK {
+		return err
+	}
+
+	if err = PlPtSend(portid, h); err != OK {
+		PlDiscard(h)
+	}
+
+	return err
+}
+
+// plown function passes the payload msg, a message marked as a payload, over
+// to process pid, NonePid while it is queued in a port (internal function
+// assumes interrupts disabled)
+func plown(msg Umsg32, pid Pid32) {
+	if i := pllookup(msg); i >= 0 {
+		PlTab[i].PlOwner = pid
+	}
+}
+
+// pldrop function frees the payload msg, a message marked as a payload, when
+// the message is discarded (internal function assumes interrupts disabled)
+func pldrop(msg Umsg32) {
+	if i := pllookup(msg); i >= 0 {
+		plfree(i, true)
+	}
+}
+
+// plrelease function frees every payload owned by process pid, e.g. when it
+// is killed (internal function assumes interrupts disabled)
+func plrelease(pid Pid32) {
+	for i := int32(0); int(i) < NPAYLOAD; i++ {
+		if PlTab[i].PlState == PlUsed && PlTab[i].PlOwner == pid {
+			plfree(i, true)
+		}
+	}
+}
@@ -15,7 +15,6 @@ ptdelete.c
 package include
 
 import (
-	"fmt"
 	"unsafe"
 )
 
@@ -38,12 +37,27 @@ const (
 // DisposeFunc function specify how to dispose of messages when deleting or reseting port
 type DisposeFunc func(Umsg32) error
 
+// PtPrioNormal is the priority of messages sent without a priority
+const PtPrioNormal int32 = 0
+
 // MsgNode struct is a node on list of messages
 type MsgNode struct {
 	PtMsg  Umsg32   // a one-word message
+	PtPrio int32    // priority of the message, higher is received first
+	PtPl   bool     // true if PtMsg is a payload handle sent by PlPtSend()
 	PtNext *MsgNode // pointer to next node on list
 }
 
+// PtStat struct is the status of a port returned by PtStatus
+type PtStat struct {
+	PsState     uint16 // port state: free, limbo, alloc
+	PsCount     int32  // number of messages queued in the port
+	PsMaxCnt    uint16 // max messages to be queued
+	PsSenders   int32  // number of processes waiting to send to the full port
+	PsReceivers int32  // number of processes waiting to receive from the empty port
+	PsSeq       int32  // current sequence number of the port
+}
+
 // PtEntry struct is the entry in port table
 type PtEntry struct {
 	PtSsem Sid32 // sender semaphore
@@ -53,7 +67,7 @@ type PtEntry struct {
 	PtMaxCnt uint16 // max messages to be queued, used in port reset
 	PtSeq    int32  // sequence change at creation. much like the sequence number of TCP
 
-	PtHead *MsgNode // head of message list
+	PtHead *MsgNode // head of message list, highest priority first, FIFO within a priority
 	PtTail *MsgNode // tail of message list
 }
 
@@ -80,6 +94,11 @@ func PtInit(maxmsgs int32) error {
 
 	ptfree = (*MsgNode)(_ptfree)
 
+	// the queue of processes waiting in PtSelect()
+	if err = ptselinit(); err != OK {
+		return err
+	}
+
 	// allocate port entry starting from index 0
 	PtNextID = 0
 
@@ -89,9 +108,14 @@ func PtInit(maxmsgs int32) error {
 		PortTab[i].PtSeq = 0
 	}
 
+	// no port has a name yet
+	for i := 0; i < MaxPtNames; i++ {
+		PtNameTab[i] = PnEntry{}
+	}
+
 	// create a free list of message nodes linked together
 	curr, next := ptfree, ptfree
-	for maxmsgs--; maxmsgs > 0; curr = next {
+	for maxmsgs--; maxmsgs > 0; maxmsgs, curr = maxmsgs-1, next {
 		// ++next
 		nextPtr := unsafe.Pointer(next)
 		next = (*MsgNode)(unsafe.Pointer(uintptr(nextPtr) + unsafe.Sizeof(MsgNode{})))
@@ -135,6 +159,7 @@ func PtCreate(count uint16) (int32, error) {
 
 			ptptr.PtSeq++
 			ptptr.PtMaxCnt = count
+			Trace(TracePtCreate, ptnum, int32(count))
 
 			return ptnum, OK
 		}
@@ -143,9 +168,51 @@ func PtCreate(count uint16) (int32, error) {
 	return -1, ErrEMPTY
 }
 
+// ptwait function waits on a port semaphore, for at most maxwait
+// milliseconds unless maxwait is negative
+func ptwait(sem Sid32, maxwait int32) error {
+	if maxwait < 0 {
+		return Wait(sem)
+	}
+	return WaitTime(sem, maxwait)
+}
+
 // PtSend function send a message to a port by adding
 // it to the tail of queue, blocking it if port is full.
 func PtSend(portid int32, msg Umsg32) error {
+	return ptsend(portid, msg, PtPrioNormal, false, -1)
+}
+
+// PtSendPrio function is PtSend with a priority: the message is received
+// before the queued messages of lower priority, after those of higher or
+// equal priority
+func PtSendPrio(portid int32, msg Umsg32, prio int32) error {
+	return ptsend(portid, msg, prio, false, -1)
+}
+
+// PtSendTime function is PtSend that gives up with ErrTIMEOUT if the
+// port is still full after maxwait milliseconds
+func PtSendTime(portid int32, msg Umsg32, maxwait int32) error {
+	if maxwait < 0 {
+		return ErrSYSERR
+	}
+	return ptsend(portid, msg, PtPrioNormal, false, maxwait)
+}
+
+// PtTrySend function sends a message to a port only if it can be done
+// without blocking, otherwise it fails with ErrWOULDBLOCK
+func PtTrySend(portid int32, msg Umsg32) error {
+	err := ptsend(portid, msg, PtPrioNormal, false, 0)
+	if err == ErrTIMEOUT { // the port is full
+		return ErrWOULDBLOCK
+	}
+	return err
+}
+
+// ptsend function does the work of PtSend, PtSendPrio, PtSendTime and PtTrySend.
+// pl is true if msg is a payload handle. maxwait is the timeout in
+// milliseconds, negative means no timeout
+func ptsend(portid int32, msg Umsg32, prio int32, pl bool, maxwait int32) error {
 	mask := Disable()
 	defer Restore(mask)
 
@@ -160,7 +227,12 @@ func PtSend(portid int32, msg Umsg32) error {
 	}
 
 	seq := ptptr.PtSeq // record the orignal sequence
-	if Wait(ptptr.PtSsem) != OK || ptptr.PtState != PtStateAlloc || ptptr.PtSeq != seq {
+	err := ptwait(ptptr.PtSsem, maxwait)
+	if err == ErrTIMEOUT {
+		// the semaphore count has been given back, nothing to undo
+		return ErrTIMEOUT
+	}
+	if err != OK || ptptr.PtState != PtStateAlloc || ptptr.PtSeq != seq {
 		// because Wait() could cause current process into waitting queue,
 		// the portid-th entry in port table could be deleted or reseted by another process.
 		// so we need to recheck its state and sequence after switch back
@@ -179,47 +251,106 @@ func PtSend(portid int32, msg Umsg32) error {
 	// copy the input msg into it
 	msgNode.PtNext = nil
 	msgNode.PtMsg = msg
+	msgNode.PtPrio = prio
+	msgNode.PtPl = pl
 
 	// link into queue for the portid port entry
 	tailNode := ptptr.PtTail
 	if tailNode == nil {
 		ptptr.PtHead = msgNode
 		ptptr.PtTail = msgNode
-	} else {
+	} else if tailNode.PtPrio >= prio {
+		// the common case, nothing queued has a lower priority
 		tailNode.PtNext = msgNode
 		ptptr.PtTail = msgNode
+	} else {
+		// insert before the first message of lower priority,
+		// which exists since the tail has one
+		var prev *MsgNode
+		curr := ptptr.PtHead
+		for curr.PtPrio >= prio {
+			prev = curr
+			curr = curr.PtNext
+		}
+
+		msgNode.PtNext = curr
+		if prev == nil {
+			ptptr.PtHead = msgNode
+		} else {
+			prev.PtNext = msgNode
+		}
+	}
+
+	if pl {
+		plown(msg, NonePid) // a payload belongs to nobody while queued
 	}
+	Trace(TracePtSend, portid, int32(msg))
 
 	// let the reveiver know that there is msg avilable
 	Signal(ptptr.PtRsem)
 
+	// the port became receivable, let the selecting processes see it
+	ptselwake()
+
 	return OK
 }
 
 // PtRecv function reveive a message from a port, blocking if port empty
 func PtRecv(portid int32) (Umsg32, error) {
+	msg, _, err := ptrecv(portid, -1)
+	return msg, err
+}
+
+// PtRecvTime function is PtRecv that gives up with ErrTIMEOUT if the
+// port is still empty after maxwait milliseconds
+func PtRecvTime(portid int32, maxwait int32) (Umsg32, error) {
+	if maxwait < 0 {
+		return NoneMsg, ErrSYSERR
+	}
+	msg, _, err := ptrecv(portid, maxwait)
+	return msg, err
+}
+
+// PtTryRecv function receives a message from a port only if one is
+// queued, otherwise it fails with ErrWOULDBLOCK
+func PtTryRecv(portid int32) (Umsg32, error) {
+	msg, _, err := ptrecv(portid, 0)
+	if err == ErrTIMEOUT { // the port is empty
+		return NoneMsg, ErrWOULDBLOCK
+	}
+	return msg, err
+}
+
+// ptrecv function does the work of PtRecv, PtRecvTime and PtTryRecv, it
+// also tells if the message is a payload handle. maxwait is the timeout in
+// milliseconds, negative means no timeout
+func ptrecv(portid int32, maxwait int32) (Umsg32, bool, error) {
 	mask := Disable()
 	defer Restore(mask)
 
 	if IsBadPort(portid) {
-		return NoneMsg, ErrSYSERR
+		return NoneMsg, false, ErrSYSERR
 	}
 
 	ptptr := &PortTab[portid]
 	if ptptr.PtState != PtStateAlloc {
 		// can only reveive message from allocated port
-		return NoneMsg, ErrEMPTY
+		return NoneMsg, false, ErrEMPTY
 	}
 
 	seq := ptptr.PtSeq
-	if Wait(ptptr.PtRsem) != OK || ptptr.PtState != PtStateAlloc || ptptr.PtSeq != seq {
+	err := ptwait(ptptr.PtRsem, maxwait)
+	if err == ErrTIMEOUT {
+		return NoneMsg, false, ErrTIMEOUT
+	}
+	if err != OK || ptptr.PtState != PtStateAlloc || ptptr.PtSeq != seq {
 		// similar recheck with PtSend()
-		return NoneMsg, ErrSYSERR
+		return NoneMsg, false, ErrSYSERR
 	}
 
 	// dequeue first message that is waiting in the port
 	msgNode := ptptr.PtHead
-	msg := msgNode.PtMsg
+	msg, pl := msgNode.PtMsg, msgNode.PtPl
 
 	if ptptr.PtHead == ptptr.PtTail { // delete last node
 		ptptr.PtHead = nil
@@ -232,10 +363,62 @@ func PtRecv(portid int32) (Umsg32, error) {
 	msgNode.PtNext = ptfree
 	ptfree = msgNode
 
+	if pl {
+		plown(msg, CurrPid) // a payload now belongs to the receiver
+	}
+	Trace(TracePtRecv, portid, int32(msg))
+
 	// let sender know that a message has been received
 	Signal(ptptr.PtSsem)
 
-	return msg, OK
+	// the port became sendable, let the selecting processes see it
+	ptselwake()
+
+	return msg, pl, OK
+}
+
+// PtCount function returns the number of messages queued in a port, or
+// minus the number of processes waiting to receive if it is empty
+func PtCount(portid int32) (int32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadPort(portid) || PortTab[portid].PtState != PtStateAlloc {
+		return 0, ErrSYSERR
+	}
+
+	return SemTab[PortTab[portid].PtRsem].SCount, OK
+}
+
+// PtStatus function returns the state, the queued messages, the capacity,
+// the waiting processes and the sequence number of a port. Only the state
+// and the sequence number are meaningful for a port that is not allocated
+func PtStatus(portid int32) (PtStat, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadPort(portid) {
+		return PtStat{}, ErrSYSERR
+	}
+
+	ptptr := &PortTab[portid]
+	st := PtStat{PsState: ptptr.PtState, PsMaxCnt: ptptr.PtMaxCnt, PsSeq: ptptr.PtSeq}
+	if ptptr.PtState != PtStateAlloc {
+		return st, OK
+	}
+
+	// a negative receiver count means waiting receivers and no message,
+	// a negative sender count means waiting senders and a full port
+	if rcount := SemTab[ptptr.PtRsem].SCount; rcount < 0 {
+		st.PsReceivers = -rcount
+	} else {
+		st.PsCount = rcount
+	}
+	if scount := SemTab[ptptr.PtSsem].SCount; scount < 0 {
+		st.PsSenders = -scount
+	}
+
+	return st, OK
 }
 
 // _ptclear function used by PtDelete and PtReset to delete or reset
@@ -257,7 +440,10 @@ func _ptclear(ptptr *PtEntry, newstate uint16, dispose DisposeFunc) {
 		for ; walk != nil; walk = walk.PtNext { // dispose remaining message
 			err := dispose(walk.PtMsg) // could cause a rescheduling
 			if err != OK {
-				fmt.Printf("dispose message error: %v", err)
+				Kprintf(KlWarn, KsPort, "dispose message error: %v", err)
+			}
+			if walk.PtPl {
+				pldrop(walk.PtMsg) // free a payload dispose did not take
 			}
 		}
 
@@ -285,6 +471,9 @@ func _ptclear(ptptr *PtEntry, newstate uint16, dispose DisposeFunc) {
 	// update the new state
 	ptptr.PtState = newstate
 
+	// selecting processes find the port reset or gone
+	ptselwake()
+
 	return
 }
 
@@ -302,6 +491,13 @@ func PtDelete(portid int32, disp DisposeFunc) error {
 		return ErrSYSERR
 	}
 
+	// the names and the subscription of the port would refer to the next
+	// port allocated here. Remove them before _ptclear can reschedule and
+	// let another process allocate the port and name it again
+	ptunname(portid)
+	tpunport(portid)
+
+	Trace(TracePtDelete, portid, 0)
 	_ptclear(ptptr, PtStateFree, disp)
 
 	// deleted port entry is the next port id to be allocated
@@ -325,6 +521,7 @@ func PtReset(portid int32, disp DisposeFunc) error {
 		return ErrSYSERR
 	}
 
+	Trace(TracePtReset, portid, 0)
 	_ptclear(ptptr, PtStateAlloc, disp)
 
 	return OK
@@ -24,16 +24,77 @@ import (
 
 // process state constants
 const (
-	PrFree    uint16 = 0 // process table entry is unused
-	PrCurr    uint16 = 1 // process is currently running
-	PrReady   uint16 = 2 // process is on ready queue
-	PrRecv    uint16 = 3 // process waiting for message
-	PrSleep   uint16 = 4 // process is sleeping
-	PrSusp    uint16 = 5 // process is suspended
-	PrWait    uint16 = 6 // process is on semaphore queue
-	PrRecTime uint16 = 7 // process is receiving with timeout
+	PrFree    uint16 = 0  // process table entry is unused
+	PrCurr    uint16 = 1  // process is currently running
+	PrReady   uint16 = 2  // process is on ready queue
+	PrRecv    uint16 = 3  // process waiting for message
+	PrSleep   uint16 = 4  // process is sleeping
+	PrSusp    uint16 = 5  // process is suspended
+	PrWait    uint16 = 6  // process is on semaphore queue
+	PrRecTime uint16 = 7  // process is receiving with timeout
+	PrCond    uint16 = 8  // process is on condition variable queue
+	PrRwWait  uint16 = 9  // process is on reader-writer lock queue
+	PrEvent   uint16 = 10 // process is on event group queue
+	PrBarrier uint16 = 11 // process is on barrier queue
+	PrSend    uint16 = 12 // process is on the sender queue of a receiver
+	PrSelect  uint16 = 13 // process is on the port select queue
 )
 
+// wakeup reasons, why a blocked process was made ready again
+const (
+	WkOK      uint8 = 0 // what the process waited for happened
+	WkTimeout uint8 = 1 // the timed wait expired
+	WkDeleted uint8 = 2 // what the process waited on was deleted
+	WkReset   uint8 = 3 // what the process waited on was reset
+)
+
+// prStateNames maps the process state to the name shown in process listings
+var prStateNames = [...]string{
+	PrFree:    "free",
+	PrCurr:    "curr",
+	PrReady:   "ready",
+	PrRecv:    "recv",
+	PrSleep:   "sleep",
+	PrSusp:    "susp",
+	PrWait:    "wait",
+	PrRecTime: "rectim",
+	PrCond:    "cond",
+	PrRwWait:  "rwlock",
+	PrEvent:   "event",
+	PrBarrier: "barrier",
+	PrSend:    "send",
+	PrSelect:  "select",
+}
+
+// WakeErr function returns the error a blocking call reports for the
+// wakeup reason of the process, OK if what it waited for happened
+func WakeErr(reason uint8) error {
+	switch reason {
+	case WkTimeout:
+		return ErrTIMEOUT
+	case WkDeleted:
+		return ErrDELETED
+	case WkReset:
+		return ErrRESET
+	}
+	return OK
+}
+
+// WakeReady function records why the blocked process pid is released and
+// makes it ready, e.g. WkDeleted when the object it waits on is deleted
+func WakeReady(pid Pid32, reason uint8) error {
+	Proctab[pid].PrWake = reason
+	return Ready(pid)
+}
+
+// PrStateName function returns the printable name of a process state
+func PrStateName(state uint16) string {
+	if int(state) >= len(prStateNames) || prStateNames[state] == "" {
+		return "unknown"
+	}
+	return prStateNames[state]
+}
+
 // miscellaneous
 const (
 	PNMLen     uint16 = 16         // length of process's name
@@ -70,12 +131,32 @@ type ProcEnt struct {
 
 	PrName   [PNMLen]byte // process name
 	PrSem    Sid32        // semaphore on which process waits
+	PrCv     Cid32        // condition variable on which process waits
+	PrRw     Rwid32       // reader-writer lock on which process waits
+	PrEv     Evid32       // event group on which process waits
+	PrBar    Brid32       // barrier on which process waits
 	PrParent Pid32        // ID of the creating process
 
-	PrMsg    Umsg32 // message sent to this process
-	PrHasMsg bool   // true if msg is valid
+	PrMsg     Umsg32  // message sent to this process
+	PrHasMsg  bool    // true if msg is valid, or the mailbox holds messages
+	PrMsgFrom Pid32   // process that sent msg
+	PrMsgPl   bool    // true if msg is a payload handle sent by PlSend()
+	PrMbox    Mailbox // optional multi-message mailbox, see mailbox.go
+
+	PrSendQ   Qid16  // queue id of processes waiting in SendSync() for this one
+	PrSendTo  Pid32  // receiver the process waits for in SendSync()
+	PrSendMsg Umsg32 // message the process waits to hand over in SendSync()
 
 	PrDesc [NDesc]int16 // device descriptors for process
+
+	PrRunTicks uint32 // milliseconds the process has been running since it was last switched in
+
+	PrTimed bool  // true if the timer node of the process is on the sleep queue
+	PrWake  uint8 // reason the last wait of the process ended, WkOK, WkTimeout, ...
+
+	PrEvWant uint32 // event bits the process waits for
+	PrEvOpts uint8  // EvAll and EvClear options of the wait
+	PrEvGot  uint32 // event bits that satisfied the wait
 }
 
 // Proctab is the process table
@@ -99,8 +180,10 @@ func Ready(pid Pid32) error {
 	}
 
 	prptr := &Proctab[pid]
+	CancelTimer(pid) // a ready process no longer needs its timeout
 	prptr.PrState = PrReady
 	Insert(pid, ReadyList, int32(prptr.PrPrio))
+	Trace(TraceReady, int32(pid), int32(prptr.PrPrio))
 	Resched()
 
 	return OK
@@ -189,6 +272,12 @@ func ChPrio(pid Pid32, np Pri16) (Pri16, error) {
 	op := prptr.PrPrio
 	prptr.PrPrio = np
 
+	// keep the queue of a priority ordered semaphore in order
+	if prptr.PrState == PrWait && SemTab[prptr.PrSem].SPolicy == SemPrio {
+		GetItem(pid)
+		Insert(pid, SemTab[prptr.PrSem].SQueue, int32(np))
+	}
+
 	return op, OK
 }
 
@@ -269,8 +358,16 @@ func Create(funcAddr uintptr, ssize uint32, priority Pri16, name [PNMLen]byte, n
 	prptr.PrStkLen = ssize
 	prptr.PrName = name
 	prptr.PrSem = -1
+	prptr.PrCv = NoneCond
+	prptr.PrRw = NoneRwLock
+	prptr.PrEv = NoneEvent
+	prptr.PrBar = NoneBarrier
 	prptr.PrParent = GetPid()
 	prptr.PrHasMsg = false
+	prptr.PrMbox = Mailbox{MbSsem: NoneSem}
+	prptr.PrSendTo = NonePid
+	prptr.PrTimed = false
+	prptr.PrWake = WkOK
 
 	prptr.PrDesc[0] = CONSOLE // stdin
 	prptr.PrDesc[1] = CONSOLE // stdout
@@ -391,7 +488,68 @@ func NewPid() (Pid32, error) {
 
 // Kill function kill a process and remove it from the system
 func Kill(pid Pid32) error {
-	// TODO: implement it
+	mask := Disable()
+	defer Restore(mask)
+
+	// the null process cannot be killed
+	if IsBadPid(pid) || pid == Pid32(NULLProc) {
+		return ErrSYSERR
+	}
+
+	prptr := &Proctab[pid]
+	PrCount--
+
+	// the cleanup below can ready other processes, none of them may run
+	// before the state of the killed process is switched
+	ReschedCntl(DeferStart)
+
+	// let the parent know that the child has exited, without waiting
+	// for room in its mailbox
+	send(prptr.PrParent, Umsg32(pid), pid, false, false)
+
+	// release the mailbox and the senders waiting for a rendezvous,
+	// blocked senders return with ErrDELETED
+	mbfree(prptr)
+	sendflush(prptr)
+
+	// free the payloads sent to it and not read yet, and end its
+	// topic subscriptions
+	plrelease(pid)
+	tpkill(pid)
+
+	// hand the mutexes and reader-writer locks it holds over to their
+	// next waiters, and leave the lock queue it may wait on
+	mxkill(pid)
+	rwkill(pid)
+
+	FreeStk(unsafe.Pointer(prptr.PrStkBase), prptr.PrStkLen)
+
+	switch prptr.PrState {
+	case PrCurr:
+		prptr.PrState = PrFree // suicide
+		Resched()
+	case PrSleep, PrRecTime:
+		Unsleep(pid) // remove it from the sleep queue
+		prptr.PrState = PrFree
+	case PrRwWait:
+		prptr.PrState = PrFree // rwkill() has taken it off the lock queue
+	case PrBarrier:
+		barleave(pid) // leave the barrier, it is one arrival short now
+		prptr.PrState = PrFree
+	case PrWait:
+		// give back the count it took from the semaphore
+		SemTab[prptr.PrSem].SCount++
+		fallthrough
+	case PrReady, PrCond, PrEvent, PrSend, PrSelect:
+		CancelTimer(pid) // it may wait with a timeout
+		GetItem(pid)     // remove it from the queue it is on
+		fallthrough
+	default:
+		prptr.PrState = PrFree
+	}
+
+	ReschedCntl(DeferStop) // a suicide switches to another process here
+
 	return OK
 }
 
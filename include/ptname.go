@@ -0,0 +1,177 @@
+/*
+ptname.go named ports
+
+A port is known by its index in PortTab, which has to be passed around by
+hand and is reused once the port is deleted. The name table lets a port
+be registered under a string name and looked up by other processes. A
+lookup returns a PtHandle carrying the port index together with its
+current PtSeq, so an operation through a handle fails instead of reaching
+another port when the port has been reset, or deleted and allocated again,
+since the lookup. PtDelete() removes the names of the port.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+const (
+	// MaxPtNames is the maximum number of registered port names
+	MaxPtNames int = MaxPorts
+	// PtNameLen is the maximum length of a port name
+	PtNameLen int = 16
+)
+
+// PtHandle struct identifies a port at a given sequence
+type PtHandle struct {
+	PhPort int32 // index of the port in PortTab
+	PhSeq  int32 // sequence of the port when the handle was made
+}
+
+// PnEntry struct is the entry in the port name table
+type PnEntry struct {
+	PnUsed bool            // true if the entry holds a name
+	PnName [PtNameLen]byte // the name, padded with zero bytes
+	PnPort int32           // port registered under the name
+}
+
+// PtNameTab is the port name table
+var PtNameTab [MaxPtNames]PnEntry
+
+// ptname function converts name to the fixed size form of the name table
+func ptname(name string) ([PtNameLen]byte, bool) {
+	var b [PtNameLen]byte
+	if len(name) == 0 || len(name) > PtNameLen {
+		return b, false
+	}
+
+	copy(b[:], name)
+	return b, true
+}
+
+// ptfindname function returns the name table index of a name, -1 if it is not registered
+func ptfindname(b [PtNameLen]byte) int {
+	for i := 0; i < MaxPtNames; i++ {
+		if PtNameTab[i].PnUsed && PtNameTab[i].PnName == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// PtRegister function registers an allocated port under name, which must
+// not be registered already
+func PtRegister(name string, portid int32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	b, ok := ptname(name)
+	if !ok || IsBadPort(portid) || PortTab[portid].PtState != PtStateAlloc || ptfindname(b) >= 0 {
+		return ErrSYSERR
+	}
+
+	for i := 0; i < MaxPtNames; i++ {
+		pnptr := &PtNameTab[i]
+		if !pnptr.PnUsed {
+			pnptr.PnUsed = true
+			pnptr.PnName = b
+			pnptr.PnPort = portid
+			return OK
+		}
+	}
+
+	return ErrEMPTY
+}
+
+// PtUnregister function removes a name from the name table
+func PtUnregister(name string) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	b, ok := ptname(name)
+	if !ok {
+		return ErrSYSERR
+	}
+
+	i := ptfindname(b)
+	if i < 0 {
+		return ErrSYSERR
+	}
+	PtNameTab[i] = PnEntry{}
+
+	return OK
+}
+
+// PtLookup function returns a handle to the port registered under name
+func PtLookup(name string) (PtHandle, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	b, ok := ptname(name)
+	if !ok {
+		return PtHandle{PhPort: -1}, ErrSYSERR
+	}
+
+	i := ptfindname(b)
+	if i < 0 {
+		return PtHandle{PhPort: -1}, ErrSYSERR
+	}
+
+	portid := PtNameTab[i].PnPort
+	return PtHandle{PhPort: portid, PhSeq: PortTab[portid].PtSeq}, OK
+}
+
+// PtResolve function returns the port index of a handle, or ErrSYSERR if
+// the port has been reset or deleted since the handle was made
+func PtResolve(h PtHandle) (int32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadPort(h.PhPort) {
+		return -1, ErrSYSERR
+	}
+
+	ptptr := &PortTab[h.PhPort]
+	if ptptr.PtState != PtStateAlloc || ptptr.PtSeq != h.PhSeq {
+		return -1, ErrSYSERR
+	}
+
+	return h.PhPort, OK
+}
+
+// PtHSend function is PtSend through a handle, failing if the handle is stale
+func PtHSend(h PtHandle, msg Umsg32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	// interrupts stay disabled, so the port cannot change before PtSend checks it
+	portid, err := PtResolve(h)
+	if err != OK {
+		return err
+	}
+
+	return PtSend(portid, msg)
+}
+
+// PtHRecv function is PtRecv through a handle, failing if the handle is stale
+func PtHRecv(h PtHandle) (Umsg32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	portid, err := PtResolve(h)
+	if err != OK {
+		return NoneMsg, err
+	}
+
+	return PtRecv(portid)
+}
+
+// ptunname function removes every name of a port, when it is deleted
+// (internal function assumes interrupts disabled)
+func ptunname(portid int32) {
+	for i := 0; i < MaxPtNames; i++ {
+		if PtNameTab[i].PnUsed && PtNameTab[i].PnPort == portid {
+			PtNameTab[i] = PnEntry{}
+		}
+	}
+}
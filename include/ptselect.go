@@ -0,0 +1,131 @@
+/*
+ptselect.go waiting on several ports at once
+
+PtSelect() is the select statement of ports: it is given a list of cases,
+each one a port to receive from or to send to, and performs the first one
+that can be done without blocking. If none can, the process waits, in the
+PrSelect state, on the global select queue in Queuetab. Every change of a
+port, a message sent or received or the port reset or deleted, readies
+all the processes on the select queue, and each of them polls its cases
+again. Unlike the select of Go, ready cases are taken in the order they
+are listed, not at random.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+// select operations
+const (
+	PtOpRecv uint8 = 1 // receive a message from the port
+	PtOpSend uint8 = 2 // send PcMsg to the port
+)
+
+// PtCase struct is one case of PtSelect
+type PtCase struct {
+	PcPort int32  // port to operate on
+	PcOp   uint8  // PtOpRecv or PtOpSend
+	PcMsg  Umsg32 // message to send with PtOpSend
+	PcPrio int32  // priority of the message sent with PtOpSend
+}
+
+// ptselq is the queue id of the processes waiting in PtSelect
+var ptselq Qid16
+
+// ptselinit function allocates the select queue (called by PtInit)
+func ptselinit() error {
+	q, err := NewQueue()
+	if err != OK {
+		return err
+	}
+	ptselq = q
+
+	return OK
+}
+
+// ptpoll function performs the first case that does not block and returns
+// its index, with the message received for PtOpRecv. It returns -1 and
+// ErrTIMEOUT if every case would block (internal function assumes
+// interrupts disabled)
+func ptpoll(cases []PtCase) (int, Umsg32, error) {
+	for i, c := range cases {
+		var msg Umsg32
+		var err error
+
+		if c.PcOp == PtOpRecv {
+			msg, _, err = ptrecv(c.PcPort, 0)
+		} else {
+			msg, err = c.PcMsg, ptsend(c.PcPort, c.PcMsg, c.PcPrio, false, 0)
+		}
+
+		if err != ErrTIMEOUT { // done, or the port is gone
+			return i, msg, err
+		}
+	}
+
+	return -1, NoneMsg, ErrTIMEOUT
+}
+
+// PtSelect function waits until one of the cases can be done, performs it
+// and returns its index, with the message received for PtOpRecv. maxwait is
+// the timeout in milliseconds, negative means no timeout; on timeout it
+// returns -1 and ErrTIMEOUT. An error of the port of a case, e.g. deleted
+// while waiting, is returned with the index of the case
+func PtSelect(cases []PtCase, maxwait int32) (int, Umsg32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if len(cases) == 0 {
+		return -1, NoneMsg, ErrSYSERR
+	}
+	for _, c := range cases {
+		if IsBadPort(c.PcPort) || (c.PcOp != PtOpRecv && c.PcOp != PtOpSend) {
+			return -1, NoneMsg, ErrSYSERR
+		}
+	}
+
+	prptr := &Proctab[CurrPid]
+	start := clkms()
+
+	for {
+		if i, msg, err := ptpoll(cases); err != ErrTIMEOUT {
+			return i, msg, err
+		}
+
+		// the time left when polling again after a port change
+		left := maxwait
+		if maxwait > 0 {
+			left = maxwait - int32(clkms()-start)
+		}
+		if maxwait == 0 || (maxwait > 0 && left <= 0) {
+			return -1, NoneMsg, ErrTIMEOUT
+		}
+
+		prptr.PrState = PrSelect
+		prptr.PrWake = WkOK
+		Enqueue(CurrPid, ptselq)
+		if left > 0 {
+			SetTimer(left)
+		}
+		Resched()
+
+		if prptr.PrWake == WkTimeout {
+			return -1, NoneMsg, ErrTIMEOUT
+		}
+	}
+}
+
+// ptselwake function readies every process waiting in PtSelect so they poll
+// their ports again (internal function assumes interrupts disabled)
+func ptselwake() {
+	if IsEmpty(ptselq) {
+		return
+	}
+
+	ReschedCntl(DeferStart)
+	for pid, err := GetFirst(ptselq); err == OK; pid, err = GetFirst(ptselq) {
+		Ready(pid)
+	}
+	ReschedCntl(DeferStop)
+}
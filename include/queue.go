@@ -7,7 +7,13 @@ const (
 	// 1 per process plus 2 for ready list 
 	// plus 2 for sleep list  (in clock.go)
 	// plus 2 per semaphore (in semaphore.go)
-	NQENT int = NPROC + 4 + 2*NSEM
+	// plus 2 per condition variable (in condvar.go)
+	// plus 2 per reader-writer lock (in rwlock.go)
+	// plus 2 per event group (in event.go)
+	// plus 2 per barrier (in barrier.go)
+	// plus 2 per process for its sender queue (in rendezvous.go)
+	// plus 2 for the port select queue (in ptselect.go)
+	NQENT int = NPROC + 4 + 2*NSEM + 2*NCOND + 2*NRWLOCK + 2*NEVENT + 2*NBARRIER + 2*NPROC + 2
 	// EMPTY is the NULL value for qnext or qprev index
 	EMPTY Qid16 = -1
 	// MAXKEY is the max key that can be stored in queue
@@ -26,11 +32,20 @@ type Qentry struct {
 
 // Queuetab array represents the table of process queues
 // [0, NPROC) saves the process nodes
-// [NPROC, NQENT) = 2 + 2 + 2 * NSEM, which is :
+// [NPROC, NQENT) = 2 + 2 + 2 * NSEM + 2 * NCOND + 2 * NRWLOCK + 2 * NEVENT + 2 * NBARRIER + 2 * NPROC + 2, which is :
 // 2: head and tail node for ready list;
 // 2: head and tail node for sleep list;
 // 2*NSEM: head and tail node for each semaphore;
-var Queuetab [NQENT]Qentry
+// 2*NCOND: head and tail node for each condition variable;
+// 2*NRWLOCK: head and tail node for each reader-writer lock;
+// 2*NEVENT: head and tail node for each event group;
+// 2*NBARRIER: head and tail node for each barrier;
+// 2*NPROC: head and tail node for the sender queue of each process;
+// 2: head and tail node for the port select queue;
+// [NQENT, NQENT+NPROC) saves the timer nodes, one per process, which let
+// a process wait on another queue and on the sleep list at the same time
+// (see TimerNode in clock.go)
+var Queuetab [NQENT + NPROC]Qentry
 
 // nextqid represents the next list in Queuetab to use.
 // used only in NewQueue function
@@ -57,9 +72,11 @@ func LastID(q Qid16) Qid16 {
 	return Queuetab[QueueTail(q)].Qprev
 }
 
-// IsEmpty function checks if the queue q is empty
+// IsEmpty function checks if the queue q is empty.
+// Timer nodes are not process nodes, so compare with the tail node
+// instead of checking whether the first node is a process
 func IsEmpty(q Qid16) bool {
-	return int(FirstID(q)) >= NPROC
+	return FirstID(q) == QueueTail(q)
 }
 
 // NonEmpty function checks if the queue q is not emtpy
@@ -167,6 +184,7 @@ func Insert(pid Pid32, q Qid16, key int32) error {
 
 	// insert process(pid) between prev node and curr node
 	prev := Queuetab[curr].Qprev
+	Queuetab[pid].Qkey = key
 	Queuetab[pid].Qprev = prev
 	Queuetab[pid].Qnext = curr
 	Queuetab[prev].Qnext = Qid16(pid)
@@ -0,0 +1,114 @@
+/*
+rendezvous.go synchronous send
+
+Send() never blocks: the message is stored for the receiver and the
+sender goes on. SendSync() instead makes a rendezvous, in the way of Ada
+and CSP: the sender waits, in the PrSend state, on the sender queue of
+the receiver in Queuetab until the receiver takes its message with
+Receive(), RecvClr(), RecvTime() or a selective receive. Messages
+stored by Send() are received first, then the waiting senders are served
+in arrival order.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+// SendInit function allocates the sender queue of every process entry
+func SendInit() error {
+	for i := 0; i < NPROC; i++ {
+		q, err := NewQueue()
+		if err != OK {
+			return err
+		}
+		Proctab[i].PrSendQ = q
+	}
+
+	return OK
+}
+
+// sendsync function does the work of SendSync and SendSyncTime.
+// maxwait is the timeout in milliseconds, negative means no timeout
+func sendsync(pid Pid32, msg Umsg32, maxwait int32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadPid(pid) || pid == CurrPid {
+		return ErrSYSERR
+	}
+
+	rcvptr := &Proctab[pid]
+	if rcvptr.PrState == PrFree {
+		return ErrSYSERR
+	}
+
+	if maxwait == 0 { // not allowed to wait at all
+		return ErrTIMEOUT
+	}
+
+	prptr := &Proctab[CurrPid]
+
+	// queue the sender before waking up the receiver, so the receiver
+	// finds the message when it runs
+	ReschedCntl(DeferStart)
+	prptr.PrState = PrSend
+	prptr.PrSendTo = pid
+	prptr.PrSendMsg = msg
+	prptr.PrWake = WkOK
+	Enqueue(CurrPid, rcvptr.PrSendQ)
+	if maxwait > 0 {
+		SetTimer(maxwait)
+	}
+	Trace(TraceSend, int32(pid), int32(msg))
+
+	if rcvptr.PrState == PrRecv {
+		Ready(pid)
+	} else if rcvptr.PrState == PrRecTime {
+		Unsleep(pid)
+		Ready(pid)
+	}
+	ReschedCntl(DeferStop)
+
+	if prptr.PrState == PrSend { // still waiting, give up the CPU now
+		Resched()
+	}
+
+	// the receiver has taken the message, unless timed out or it was killed
+	return WakeErr(prptr.PrWake)
+}
+
+// SendSync function sends a message to process pid and blocks until pid
+// receives it. It fails with ErrDELETED if pid is killed before
+func SendSync(pid Pid32, msg Umsg32) error {
+	return sendsync(pid, msg, -1)
+}
+
+// SendSyncTime function is SendSync that gives up after maxwait milliseconds
+// with ErrTIMEOUT, the message is then not received
+func SendSyncTime(pid Pid32, msg Umsg32, maxwait int32) error {
+	if maxwait < 0 {
+		return ErrSYSERR
+	}
+	return sendsync(pid, msg, maxwait)
+}
+
+// sendtake function takes the message of process pid waiting on a sender
+// queue and releases the sender (internal function assumes interrupts disabled)
+func sendtake(pid Pid32) Umsg32 {
+	GetItem(pid)
+	msg := Proctab[pid].PrSendMsg
+	Ready(pid) // could cause a rescheduling
+
+	return msg
+}
+
+// sendflush function releases with ErrDELETED every sender waiting for the
+// process, e.g. when it is killed (internal function assumes interrupts disabled)
+func sendflush(prptr *ProcEnt) {
+	ReschedCntl(DeferStart)
+	for pid, err := GetFirst(prptr.PrSendQ); err == OK; pid, err = GetFirst(prptr.PrSendQ) {
+		WakeReady(pid, WkDeleted)
+	}
+	ReschedCntl(DeferStop)
+}
@@ -9,10 +9,6 @@ ready.c
 
 package include
 
-import (
-	"fmt"
-)
-
 const (
 	// DeferStart means start deferred rescehduling
 	DeferStart uint8 = 1
@@ -36,13 +32,18 @@ var ReadyList Qid16
 // if you don't want current process remains eligible,
 // you should change the state to other state before call Resched()
 func Resched() {
+	if kpanic != nil { // the kernel has panicked, scheduling is halted
+		return
+	}
+
 	if def.NDefers > 0 { // reschedule is defered by os
 		def.Attempt = true // let os know that a rescheduling attempt is made
 		return
 	}
 
 	// ptold point to process table entry for the current (old soon) process
-	ptold := &Proctab[CurrPid]
+	oldpid := CurrPid
+	ptold := &Proctab[oldpid]
 
 	// the current process remains eligible
 	if ptold.PrState == PrCurr {
@@ -56,14 +57,24 @@ func Resched() {
 		// insert current process back to the priority list
 		Insert(CurrPid, ReadyList, int32(ptold.PrPrio))
 		// but current process still runs until called ctxsw
+	} else {
+		// current process blocks, record why it gives up the CPU
+		Trace(TraceBlock, int32(oldpid), int32(ptold.PrState))
 	}
 
-	// extract the process of highest priority from the ready list
-	CurrPid, _ = Dequeue(ReadyList)
+	// extract the process of highest priority from the ready list.
+	// the null process is always eligible, so the list can never be empty
+	newpid, err := Dequeue(ReadyList)
+	if err != OK {
+		Panic("resched: ready list is empty")
+	}
+	CurrPid = newpid
 	ptnew := &Proctab[CurrPid]
 	ptnew.PrState = PrCurr // update it's state to PrCurr
+	ptnew.PrRunTicks = 0   // start counting its continuous CPU time
 	Preempt = QUANTUM      // reset the preempt counter for the new process
 
+	Trace(TraceCtxsw, int32(oldpid), int32(CurrPid))
 	ctxsw(ptold.PrStkPtr, ptnew.PrStkPtr) // switch context from old process to new process
 	return
 }
@@ -71,7 +82,7 @@ func Resched() {
 // ctxsw function wraps the ctxsw written with Assembly language in ctxsw.S
 func ctxsw(oldsp, newsp *uint32) {
 	// TODO: link ctxsw.S with golang
-	fmt.Printf("context swithched from %v to %v\n", oldsp, newsp)
+	Kprintf(KlDebug, KsSched, "context swithched from %v to %v", oldsp, newsp)
 }
 
 // ReschedCntl function control whether rescheduling is defered or allowed
@@ -87,7 +98,7 @@ func ReschedCntl(d uint8) error {
 
 	} else if d == DeferStop { // stop defer rescheduling
 		if def.NDefers <= 0 { // something must going wrong
-			return ErrSYSERR
+			Panic("resched_cntl: DeferStop without DeferStart")
 		}
 
 		def.NDefers-- // decrease the total number of defer by one
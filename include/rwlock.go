@@ -0,0 +1,378 @@
+/*
+rwlock.go reader-writer locks
+
+A reader-writer lock is held either by any number of readers or by a
+single writer. Processes that cannot get the lock wait, in the PrRwWait
+state, on a queue of Queuetab in arrival order, with the Qkey of their
+node telling whether they wait to read or to write. The fairness mode
+decides who gets the lock when both readers and writers are waiting:
+
+RwReaderPref: readers get in whenever no writer holds the lock, writers
+              wait until no reader holds or waits (writers may starve);
+RwWriterPref: readers wait while any writer is waiting, a released lock
+              goes to a writer first (readers may starve);
+RwFifo:       the lock is granted in arrival order, a writer alone or a
+              run of consecutive readers at the head of the queue.
+
+The lock records how many times each process holds it for reading, so
+only a holder can release a read lock, and Kill() releases the locks of
+the killed process.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+// RwFree state: reader-writer lock table entry is available
+const RwFree uint8 = 0
+
+// RwUsed state: reader-writer lock table entry is used
+const RwUsed uint8 = 1
+
+// fairness modes
+const (
+	RwReaderPref uint8 = 1 // prefer readers
+	RwWriterPref uint8 = 2 // prefer writers
+	RwFifo       uint8 = 3 // first come, first served
+)
+
+// keys of the waiting process nodes on the lock queue
+const (
+	rwRead  int32 = 1 // the process waits to read
+	rwWrite int32 = 2 // the process waits to write
+)
+
+// RwEntry struct is the reader-writer lock table entry
+type RwEntry struct {
+	RwState   uint8        // RwFree or RwUsed
+	RwMode    uint8        // RwReaderPref, RwWriterPref or RwFifo
+	RwReaders int32        // number of read locks held, the sum of RwHolds
+	RwHolds   [NPROC]int32 // number of read locks held by each process
+	RwWriter  Pid32        // process holding the lock for writing, NonePid if none
+	RwWriters int32        // number of processes waiting to write
+	RwQueue   Qid16        // queue id of processes waiting for the lock
+}
+
+// RwTab is the reader-writer lock table
+var RwTab [NRWLOCK]RwEntry
+
+// nextrw is the next reader-writer lock index to try to allocate
+var nextrw Rwid32 = 0
+
+// IsBadRwLock function checks if reader-writer lock id is bad
+func IsBadRwLock(rw Rwid32) bool {
+	return rw < 0 || int(rw) >= NRWLOCK || RwTab[rw].RwState == RwFree
+}
+
+// RwInit function initialize the reader-writer lock table and allocate
+// the waiting queue of every entry
+func RwInit() error {
+	for i := 0; i < NRWLOCK; i++ {
+		RwTab[i].RwState = RwFree
+
+		q, err := NewQueue()
+		if err != OK {
+			return err
+		}
+		RwTab[i].RwQueue = q
+	}
+
+	nextrw = 0
+
+	return OK
+}
+
+// RwCreate function allocates an unlocked reader-writer lock of the given fairness mode
+func RwCreate(mode uint8) (Rwid32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if mode != RwReaderPref && mode != RwWriterPref && mode != RwFifo {
+		return NoneRwLock, ErrSYSERR
+	}
+
+	for i := 0; i < NRWLOCK; i++ {
+		rw := nextrw
+
+		nextrw++
+		if int(nextrw) >= NRWLOCK {
+			nextrw = 0
+		}
+
+		rwptr := &RwTab[rw]
+		if rwptr.RwState == RwFree {
+			rwptr.RwState = RwUsed
+			rwptr.RwMode = mode
+			rwptr.RwReaders = 0
+			rwptr.RwHolds = [NPROC]int32{}
+			rwptr.RwWriter = NonePid
+			rwptr.RwWriters = 0
+			return rw, OK
+		}
+	}
+
+	return NoneRwLock, ErrSYSERR
+}
+
+// RwDelete function deletes a reader-writer lock and releases the waiting processes
+func RwDelete(rw Rwid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadRwLock(rw) {
+		return ErrSYSERR
+	}
+
+	rwptr := &RwTab[rw]
+	rwptr.RwState = RwFree
+	rwptr.RwWriter = NonePid
+	rwptr.RwReaders = 0
+	rwptr.RwHolds = [NPROC]int32{}
+	rwptr.RwWriters = 0
+
+	ReschedCntl(DeferStart)
+	for pid, err := GetFirst(rwptr.RwQueue); err == OK; pid, err = GetFirst(rwptr.RwQueue) {
+		WakeReady(pid, WkDeleted)
+	}
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// rwwait function blocks the current process on the lock queue until
+// the lock is granted to it (internal function assumes interrupts disabled)
+func rwwait(rwptr *RwEntry, rw Rwid32, key int32) error {
+	prptr := &Proctab[CurrPid]
+	prptr.PrState = PrRwWait
+	prptr.PrRw = rw
+	prptr.PrWake = WkOK
+
+	Enqueue(CurrPid, rwptr.RwQueue)
+	Queuetab[CurrPid].Qkey = key
+	if key == rwWrite {
+		rwptr.RwWriters++
+	}
+
+	Resched()
+
+	// rwgrant has given us the lock, unless the lock was deleted
+	return WakeErr(prptr.PrWake)
+}
+
+// RwLockRead function acquires a reader-writer lock for reading
+func RwLockRead(rw Rwid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadRwLock(rw) {
+		return ErrSYSERR
+	}
+
+	rwptr := &RwTab[rw]
+	if rwptr.RwWriter == CurrPid {
+		return ErrDEADLOCK
+	}
+
+	canread := rwptr.RwWriter == NonePid
+	switch rwptr.RwMode {
+	case RwWriterPref:
+		canread = canread && rwptr.RwWriters == 0
+	case RwFifo:
+		canread = canread && IsEmpty(rwptr.RwQueue)
+	}
+
+	if canread {
+		rwptr.RwReaders++
+		rwptr.RwHolds[CurrPid]++
+		return OK
+	}
+
+	return rwwait(rwptr, rw, rwRead)
+}
+
+// RwLockWrite function acquires a reader-writer lock for writing
+func RwLockWrite(rw Rwid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadRwLock(rw) {
+		return ErrSYSERR
+	}
+
+	rwptr := &RwTab[rw]
+	if rwptr.RwWriter == CurrPid {
+		return ErrDEADLOCK
+	}
+
+	// a writer never overtakes waiting processes, whatever the mode
+	if rwptr.RwWriter == NonePid && rwptr.RwReaders == 0 && IsEmpty(rwptr.RwQueue) {
+		rwptr.RwWriter = CurrPid
+		return OK
+	}
+
+	return rwwait(rwptr, rw, rwWrite)
+}
+
+// RwUnlock function releases a reader-writer lock held by the caller,
+// either for reading or for writing
+func RwUnlock(rw Rwid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadRwLock(rw) {
+		return ErrSYSERR
+	}
+
+	rwptr := &RwTab[rw]
+	if rwptr.RwWriter == CurrPid {
+		rwptr.RwWriter = NonePid
+	} else if rwptr.RwHolds[CurrPid] > 0 {
+		rwptr.RwHolds[CurrPid]--
+		rwptr.RwReaders--
+	} else {
+		return ErrNOTOWNER
+	}
+
+	ReschedCntl(DeferStart)
+	rwgrant(rwptr)
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// RwDowngrade function turns the write lock held by the caller into a read
+// lock without releasing it, letting waiting readers in if the mode allows
+func RwDowngrade(rw Rwid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadRwLock(rw) {
+		return ErrSYSERR
+	}
+
+	rwptr := &RwTab[rw]
+	if rwptr.RwWriter != CurrPid {
+		return ErrNOTOWNER
+	}
+
+	rwptr.RwWriter = NonePid
+	rwptr.RwReaders++
+	rwptr.RwHolds[CurrPid]++
+
+	ReschedCntl(DeferStart)
+	rwgrant(rwptr)
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// rwtake function removes pid from the lock queue, gives it the lock it
+// waits for and makes it ready (internal function assumes rescheduling deferred)
+func rwtake(rwptr *RwEntry, pid Pid32) {
+	key := Queuetab[pid].Qkey
+	GetItem(pid)
+
+	if key == rwWrite {
+		rwptr.RwWriters--
+		rwptr.RwWriter = pid
+	} else {
+		rwptr.RwReaders++
+		rwptr.RwHolds[pid]++
+	}
+
+	Ready(pid)
+}
+
+// rwfirst function returns the first process on the lock queue waiting
+// with the given key, NonePid if none
+func rwfirst(rwptr *RwEntry, key int32) Pid32 {
+	q := rwptr.RwQueue
+	for curr := FirstID(q); curr != QueueTail(q); curr = Queuetab[curr].Qnext {
+		if Queuetab[curr].Qkey == key {
+			return Pid32(curr)
+		}
+	}
+	return NonePid
+}
+
+// rwreaders function gives the lock to every process waiting to read
+func rwreaders(rwptr *RwEntry) {
+	for pid := rwfirst(rwptr, rwRead); pid != NonePid; pid = rwfirst(rwptr, rwRead) {
+		rwtake(rwptr, pid)
+	}
+}
+
+// rwgrant function hands a reader-writer lock over to the waiting processes
+// according to its fairness mode (internal function assumes interrupts
+// disabled and rescheduling deferred)
+func rwgrant(rwptr *RwEntry) {
+	if rwptr.RwWriter != NonePid { // still held by a writer
+		return
+	}
+
+	switch rwptr.RwMode {
+	case RwReaderPref:
+		rwreaders(rwptr)
+		if rwptr.RwReaders == 0 && rwptr.RwWriters > 0 {
+			rwtake(rwptr, rwfirst(rwptr, rwWrite))
+		}
+
+	case RwWriterPref:
+		if rwptr.RwWriters > 0 {
+			if rwptr.RwReaders == 0 {
+				rwtake(rwptr, rwfirst(rwptr, rwWrite))
+			}
+			return // readers keep waiting behind the writers
+		}
+		rwreaders(rwptr)
+
+	case RwFifo:
+		// a writer alone or a run of readers from the head of the queue
+		q := rwptr.RwQueue
+		for NonEmpty(q) {
+			pid := Pid32(FirstID(q))
+			if Queuetab[pid].Qkey == rwWrite {
+				if rwptr.RwReaders == 0 {
+					rwtake(rwptr, pid)
+				}
+				return
+			}
+			rwtake(rwptr, pid)
+		}
+	}
+}
+
+// rwkill function removes process pid from the lock queue it waits on and
+// releases the locks it holds, including those granted to it before it
+// could run again, when it is killed. The waiting processes these were
+// blocking are let in (internal function assumes interrupts disabled)
+func rwkill(pid Pid32) {
+	ReschedCntl(DeferStart)
+
+	// leave the queue first, so the lock is not granted to pid again
+	if prptr := &Proctab[pid]; prptr.PrState == PrRwWait {
+		rwptr := &RwTab[prptr.PrRw]
+		if Queuetab[pid].Qkey == rwWrite {
+			rwptr.RwWriters--
+		}
+		GetItem(pid)
+		rwgrant(rwptr)
+	}
+
+	for i := 0; i < NRWLOCK; i++ {
+		rwptr := &RwTab[i]
+		if rwptr.RwState == RwFree || (rwptr.RwWriter != pid && rwptr.RwHolds[pid] == 0) {
+			continue
+		}
+
+		if rwptr.RwWriter == pid {
+			rwptr.RwWriter = NonePid
+		}
+		rwptr.RwReaders -= rwptr.RwHolds[pid]
+		rwptr.RwHolds[pid] = 0
+		rwgrant(rwptr)
+	}
+
+	ReschedCntl(DeferStop)
+}
@@ -8,6 +8,8 @@ signal.c
 semdelete.c
 semreset.c
 semcreate.c
+semcount.c
+signaln.c
 
 */
 
@@ -19,6 +21,12 @@ const SFree uint8 = 0
 // SUsed state: semaphore table entry is used
 const SUsed uint8 = 1
 
+// semaphore queueing policies
+const (
+	SemFifo uint8 = 0 // waiting processes are released in arrival order (default)
+	SemPrio uint8 = 1 // waiting processes are released in priority order, FIFO among equals
+)
+
 // SEntry struct is the semaphore table entry
 type SEntry struct {
 	// SState identify whether entry is SFree or SUsed
@@ -31,6 +39,18 @@ type SEntry struct {
 
 	// queue id of processes that are waiting on the semaphore
 	SQueue Qid16
+
+	// SPolicy is the queueing policy of SQueue, SemFifo or SemPrio
+	SPolicy uint8
+
+	// SOwner is the last process that acquired the semaphore and has not
+	// signaled it yet, NonePid if none. It is exact for semaphores used as
+	// locks and a best guess for counting semaphores, see deadlock.go
+	SOwner Pid32
+
+	// SLock is true if the semaphore was created or reset with a count of 1,
+	// i.e. it is used as a lock and SOwner is its holder
+	SLock bool
 }
 
 // SemTab is the semaphore table
@@ -59,20 +79,161 @@ func Wait(sem Sid32) error {
 	}
 
 	semptr.SCount--
+	Trace(TraceSemWait, int32(sem), semptr.SCount)
 
 	if semptr.SCount < 0 { // semaphore is not enough, current process must wait
 		prptr := &Proctab[CurrPid]
 		prptr.PrState = PrWait
 		prptr.PrSem = sem
+		prptr.PrWake = WkOK
 
 		// enqueue current process at the corresponding semaphore queue
-		Enqueue(CurrPid, semptr.SQueue)
+		semEnqueue(semptr, CurrPid)
 		// rescheduling another process to run
 		Resched()
-		// resume running when returned from Resched() after another process signal it
+		// resume running when returned from Resched() after another process signal it,
+		// the signaling process has already handed the ownership over to us,
+		// unless the semaphore was deleted or reset underneath us
+		return WakeErr(prptr.PrWake)
+	}
+
+	// semaphore is enough, current process acquires it
+	semptr.SOwner = CurrPid
+	return OK
+}
+
+// WaitTime function cause current process to wait on a semaphore for at most
+// maxwait milliseconds. It returns ErrTIMEOUT if the semaphore is not
+// signaled in time, in which case the count taken by the caller is given back.
+func WaitTime(sem Sid32, maxwait int32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadSem(sem) || maxwait < 0 {
+		return ErrSYSERR
+	}
+
+	semptr := &SemTab[sem]
+	if semptr.SState == SFree {
+		return ErrSYSERR
+	}
+
+	semptr.SCount--
+	Trace(TraceSemWait, int32(sem), semptr.SCount)
+
+	if semptr.SCount < 0 {
+		if maxwait == 0 { // not allowed to wait at all
+			semptr.SCount++
+			return ErrTIMEOUT
+		}
+
+		prptr := &Proctab[CurrPid]
+		prptr.PrState = PrWait
+		prptr.PrSem = sem
+		prptr.PrWake = WkOK
+
+		// wait on both the semaphore queue and the sleep queue
+		semEnqueue(semptr, CurrPid)
+		SetTimer(maxwait)
+		Resched()
+
+		// either signaled, then Ready() has removed the timer, or timed out,
+		// then the clock has removed us from the semaphore queue, or the
+		// semaphore was deleted or reset
+		return WakeErr(prptr.PrWake)
+	}
+
+	semptr.SOwner = CurrPid
+	return OK
+}
+
+// TryWait function acquires a semaphore if it can be done without blocking,
+// otherwise it fails with ErrWOULDBLOCK and leaves the semaphore untouched
+func TryWait(sem Sid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadSem(sem) {
+		return ErrSYSERR
+	}
+
+	semptr := &SemTab[sem]
+	if semptr.SState == SFree {
+		return ErrSYSERR
+	}
+
+	if semptr.SCount <= 0 {
+		return ErrWOULDBLOCK
+	}
+
+	semptr.SCount--
+	semptr.SOwner = CurrPid
+	Trace(TraceSemWait, int32(sem), semptr.SCount)
+
+	return OK
+}
+
+// SemCount function returns the count of a semaphore. A negative count
+// means that many processes are waiting on it
+func SemCount(sem Sid32) (int32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadSem(sem) || SemTab[sem].SState == SFree {
+		return 0, ErrSYSERR
+	}
+
+	return SemTab[sem].SCount, OK
+}
+
+// SemWaiters function returns the processes waiting on a semaphore,
+// in the order they will be released
+func SemWaiters(sem Sid32) ([]Pid32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadSem(sem) || SemTab[sem].SState == SFree {
+		return nil, ErrSYSERR
+	}
+
+	q := SemTab[sem].SQueue
+	waiters := make([]Pid32, 0, NPROC)
+	for curr := FirstID(q); curr != QueueTail(q); curr = Queuetab[curr].Qnext {
+		waiters = append(waiters, Pid32(curr))
+	}
+
+	return waiters, OK
+}
+
+// semEnqueue function puts process pid on the queue of a semaphore according
+// to its queueing policy (internal function assumes interrupts disabled)
+func semEnqueue(semptr *SEntry, pid Pid32) {
+	if semptr.SPolicy == SemPrio {
+		// Insert keeps equal keys in arrival order
+		Insert(pid, semptr.SQueue, int32(Proctab[pid].PrPrio))
+		return
+	}
+	Enqueue(pid, semptr.SQueue)
+}
+
+// SemSetPolicy function chooses the order in which the processes waiting
+// on a semaphore are released: SemFifo or SemPrio. The policy can only be
+// changed while no process is waiting
+func SemSetPolicy(sem Sid32, policy uint8) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadSem(sem) || (policy != SemFifo && policy != SemPrio) {
+		return ErrSYSERR
+	}
+
+	semptr := &SemTab[sem]
+	if semptr.SState == SFree || semptr.SCount < 0 {
+		return ErrSYSERR
 	}
 
-	// semaphore is enough or signaled from another process
+	semptr.SPolicy = policy
+
 	return OK
 }
 
@@ -92,16 +253,93 @@ func Signal(sem Sid32) error {
 
 	oldCount := semptr.SCount
 	semptr.SCount++
+	Trace(TraceSemSignal, int32(sem), semptr.SCount)
 
 	if oldCount < 0 { // oldCount processes are waiting on this semaphore
 		// need to release a waiting process from the semaphore waiting queue
 		p, _ := Dequeue(semptr.SQueue)
-		Ready(p) // could cause a rescheduling
+		semptr.SOwner = p // the released process acquires the semaphore
+		Ready(p)          // could cause a rescheduling
+	} else if semptr.SOwner == CurrPid {
+		semptr.SOwner = NonePid
 	}
 
 	return OK
 }
 
+// SignalN function signal a semaphore n times, releasing up to n waiting
+// processes. The released processes are made ready under a single deferred
+// rescheduling, so none of them runs before all of them are ready.
+func SignalN(sem Sid32, n int32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadSem(sem) || n <= 0 {
+		return ErrSYSERR
+	}
+
+	semptr := &SemTab[sem]
+	if semptr.SState == SFree {
+		return ErrSYSERR
+	}
+
+	ReschedCntl(DeferStart)
+	for released := 0; n > 0; n-- {
+		oldCount := semptr.SCount
+		semptr.SCount++
+
+		if oldCount < 0 {
+			p, _ := Dequeue(semptr.SQueue)
+			// a single released process acquires the semaphore, with
+			// several of them there is no single holder anymore
+			released++
+			if released == 1 {
+				semptr.SOwner = p
+			} else {
+				semptr.SOwner = NonePid
+			}
+			Ready(p)
+		}
+	}
+	Trace(TraceSemSignal, int32(sem), semptr.SCount)
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// SemBroadcast function releases every process currently waiting on a
+// semaphore under a single deferred rescheduling. Unlike SignalN it never
+// raises the count above zero, so a semaphore without waiters is unchanged.
+func SemBroadcast(sem Sid32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadSem(sem) {
+		return ErrSYSERR
+	}
+
+	semptr := &SemTab[sem]
+	if semptr.SState == SFree {
+		return ErrSYSERR
+	}
+
+	if semptr.SCount >= 0 { // nobody is waiting
+		return OK
+	}
+
+	semptr.SOwner = NonePid
+
+	ReschedCntl(DeferStart)
+	for ; semptr.SCount < 0; semptr.SCount++ {
+		p, _ := Dequeue(semptr.SQueue)
+		Ready(p)
+	}
+	Trace(TraceSemSignal, int32(sem), semptr.SCount)
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
 // NewSem function allocate an unused semaphore and return its index
 func NewSem() (Sid32, error) {
 	for i := 0; i < NSEM; i++ {
@@ -132,6 +370,9 @@ func SemCreate(count int32) (Sid32, error) {
 	}
 
 	SemTab[sem].SCount = count
+	SemTab[sem].SPolicy = SemFifo
+	SemTab[sem].SOwner = NonePid
+	SemTab[sem].SLock = count == 1
 
 	return sem, OK
 }
@@ -151,6 +392,7 @@ func SemDelete(sem Sid32) error {
 	}
 
 	semptr.SState = SFree
+	semptr.SOwner = NonePid
 
 	// defer resheduling before all the
 	// waiting processes are released from this semaphore waiting queue
@@ -158,10 +400,10 @@ func SemDelete(sem Sid32) error {
 	for ; semptr.SCount < 0; semptr.SCount++ {
 		pid, err := GetFirst(semptr.SQueue)
 		if err != OK {
-			return err
+			Panic("semdelete: count says processes are waiting but queue is empty")
 		}
 
-		err = Ready(pid)
+		err = WakeReady(pid, WkDeleted)
 		if err != OK {
 			return err
 		}
@@ -186,13 +428,15 @@ func SemReset(sem Sid32, count int32) error {
 
 	// defer rescheduling before free all the waiting processes
 	ReschedCntl(DeferStart)
-	for pid, err := GetFirst(semqueue); err == OK; {
-		e := Ready(pid)
+	for pid, err := GetFirst(semqueue); err == OK; pid, err = GetFirst(semqueue) {
+		e := WakeReady(pid, WkReset)
 		if e != OK {
 			return e
 		}
 	}
 	semptr.SCount = count // new count for resetted semaphore
+	semptr.SOwner = NonePid
+	semptr.SLock = count == 1
 	ReschedCntl(DeferStop)
 
 	return OK
@@ -0,0 +1,243 @@
+/*
+topic.go publish/subscribe topics
+
+A topic fans messages out to its subscribers. Every subscriber gets its
+own bounded queue, a port created by TopicSubscribe() that the subscriber
+receives from with PtRecv() or any other port call, and TopicPublish()
+sends the message to every subscriber port. When a subscriber port is
+full, the overflow policy of that subscriber decides:
+
+TpBlock:      the publisher waits until the subscriber makes room;
+TpDrop:       the new message is dropped for that subscriber;
+TpDropOldest: the oldest queued message is dropped to make room.
+
+A subscription ends with TopicUnsubscribe(), when the subscriber port is
+deleted, when the subscriber process is killed or when the topic is
+deleted. Published messages are plain messages, a payload (see
+payload.go) has a single owner and is only sent by PlSend() and
+PlPtSend().
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+const (
+	// MaxTopics is the maximum number of topics
+	MaxTopics int = 20
+	// MaxSubs is the maximum number of subscriptions, each one uses a port
+	MaxSubs int = MaxPorts
+)
+
+// overflow policies of a subscriber
+const (
+	TpBlock      uint8 = 0 // the publisher blocks
+	TpDrop       uint8 = 1 // the new message is dropped
+	TpDropOldest uint8 = 2 // the oldest message is dropped
+)
+
+// TpEntry struct is the entry in the topic table
+type TpEntry struct {
+	TpUsed bool  // true if the topic is allocated
+	TpSubs int32 // number of subscribers
+}
+
+// SbEntry struct is the entry in the subscription table
+type SbEntry struct {
+	SbUsed   bool   // true if the subscription is active
+	SbTopic  int32  // topic subscribed to
+	SbPid    Pid32  // subscriber process
+	SbPort   int32  // port the messages are delivered to
+	SbPolicy uint8  // TpBlock, TpDrop or TpDropOldest
+	SbDrops  uint32 // number of messages dropped for this subscriber
+}
+
+// TopicTab is the topic table
+var TopicTab [MaxTopics]TpEntry
+
+// SubTab is the subscription table
+var SubTab [MaxSubs]SbEntry
+
+// IsBadTopic function checks if topic id is bad
+func IsBadTopic(topic int32) bool {
+	return topic < 0 || int(topic) >= MaxTopics || !TopicTab[topic].TpUsed
+}
+
+// TopicCreate function allocates a topic without subscribers
+func TopicCreate() (int32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	for i := int32(0); int(i) < MaxTopics; i++ {
+		if !TopicTab[i].TpUsed {
+			TopicTab[i] = TpEntry{TpUsed: true}
+			return i, OK
+		}
+	}
+
+	return -1, ErrEMPTY
+}
+
+// TopicDelete function deletes a topic and the ports of its subscribers
+func TopicDelete(topic int32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadTopic(topic) {
+		return ErrSYSERR
+	}
+
+	// deleting the ports can reschedule, no process may subscribe to the
+	// topic in the meantime
+	ReschedCntl(DeferStart)
+	for i := 0; i < MaxSubs; i++ {
+		if SubTab[i].SbUsed && SubTab[i].SbTopic == topic {
+			sbdelete(i)
+		}
+	}
+	TopicTab[topic] = TpEntry{}
+	ReschedCntl(DeferStop)
+
+	return OK
+}
+
+// TopicSubscribe function subscribes the calling process to a topic with a
+// queue of count messages and the given overflow policy. It returns the
+// port the process receives the published messages from
+func TopicSubscribe(topic int32, count uint16, policy uint8) (int32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadTopic(topic) || policy > TpDropOldest {
+		return -1, ErrSYSERR
+	}
+
+	for i := 0; i < MaxSubs; i++ {
+		sbptr := &SubTab[i]
+		if sbptr.SbUsed {
+			continue
+		}
+
+		portid, err := PtCreate(count)
+		if err != OK {
+			return -1, err
+		}
+
+		*sbptr = SbEntry{SbUsed: true, SbTopic: topic, SbPid: CurrPid, SbPort: portid, SbPolicy: policy}
+		TopicTab[topic].TpSubs++
+		return portid, OK
+	}
+
+	return -1, ErrEMPTY
+}
+
+// TopicUnsubscribe function ends the subscription delivered to portid and
+// deletes the port, dropping the messages not received yet
+func TopicUnsubscribe(topic int32, portid int32) error {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadTopic(topic) {
+		return ErrSYSERR
+	}
+
+	for i := 0; i < MaxSubs; i++ {
+		if SubTab[i].SbUsed && SubTab[i].SbTopic == topic && SubTab[i].SbPort == portid {
+			sbdelete(i)
+			return OK
+		}
+	}
+
+	return ErrSYSERR
+}
+
+// TopicPublish function sends msg to every subscriber of a topic and returns
+// the number of subscribers it was delivered to. With TpBlock subscribers it
+// may block, in which case subscriptions can end before delivery
+func TopicPublish(topic int32, msg Umsg32) (int32, error) {
+	mask := Disable()
+	defer Restore(mask)
+
+	if IsBadTopic(topic) {
+		return 0, ErrSYSERR
+	}
+
+	delivered := int32(0)
+	for i := 0; i < MaxSubs; i++ {
+		sbptr := &SubTab[i]
+		if !sbptr.SbUsed || sbptr.SbTopic != topic {
+			continue
+		}
+
+		if sbpublish(sbptr, msg) == OK {
+			delivered++
+		}
+
+		if IsBadTopic(topic) { // deleted while we were blocked
+			break
+		}
+	}
+
+	return delivered, OK
+}
+
+// sbpublish function delivers msg to one subscriber according to its policy
+// (internal function assumes interrupts disabled)
+func sbpublish(sbptr *SbEntry, msg Umsg32) error {
+	if sbptr.SbPolicy == TpBlock {
+		return PtSend(sbptr.SbPort, msg)
+	}
+
+	err := PtTrySend(sbptr.SbPort, msg)
+	if err != ErrWOULDBLOCK {
+		return err
+	}
+
+	sbptr.SbDrops++
+	if sbptr.SbPolicy == TpDrop {
+		return err
+	}
+
+	// TpDropOldest: make room for msg by dropping the oldest message,
+	// which can be a payload sent to the port by PlPtSend()
+	if old, pl, e := ptrecv(sbptr.SbPort, 0); e == OK && pl {
+		pldrop(old)
+	}
+
+	return PtTrySend(sbptr.SbPort, msg)
+}
+
+// sbdelete function ends subscription i and deletes its port
+// (internal function assumes interrupts disabled)
+func sbdelete(i int) {
+	sbptr := &SubTab[i]
+	portid := sbptr.SbPort
+
+	TopicTab[sbptr.SbTopic].TpSubs--
+	*sbptr = SbEntry{}
+
+	PtDelete(portid, func(Umsg32) error { return OK })
+}
+
+// tpunport function ends the subscription delivered to a port being
+// deleted (internal function assumes interrupts disabled)
+func tpunport(portid int32) {
+	for i := 0; i < MaxSubs; i++ {
+		sbptr := &SubTab[i]
+		if sbptr.SbUsed && sbptr.SbPort == portid {
+			TopicTab[sbptr.SbTopic].TpSubs--
+			*sbptr = SbEntry{}
+		}
+	}
+}
+
+// tpkill function ends the subscriptions of process pid when it is killed
+// (internal function assumes interrupts disabled)
+func tpkill(pid Pid32) {
+	for i := 0; i < MaxSubs; i++ {
+		if SubTab[i].SbUsed && SubTab[i].SbPid == pid {
+			sbdelete(i)
+		}
+	}
+}
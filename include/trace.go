@@ -0,0 +1,238 @@
+/* trace.go kernel event tracing
+
+The kernel records what it does (context switch, ready, block, wakeup,
+semaphore, message, port and memory operations) into a fixed-size ring
+buffer. Each event carries a virtual timestamp derived from the clock
+(clktime and count1000), so the same workload always produces the same
+trace. The ring can be exported in the Chrome trace JSON format and
+viewed as a timeline in chrome://tracing or Perfetto.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// TraceSize is the number of events kept in the trace ring buffer
+const TraceSize int = 1024
+
+// trace event kinds
+const (
+	TraceCtxsw     uint8 = 1  // context switch. arg1: old pid, arg2: new pid
+	TraceReady     uint8 = 2  // process made ready. arg1: pid, arg2: priority
+	TraceBlock     uint8 = 3  // process gives up CPU. arg1: pid, arg2: new process state
+	TraceWakeup    uint8 = 4  // process awakened from sleep queue. arg1: pid, arg2: 1 if a timed wait expired
+	TraceSemWait   uint8 = 5  // Wait on semaphore. arg1: sem, arg2: count after wait
+	TraceSemSignal uint8 = 6  // Signal a semaphore. arg1: sem, arg2: count after signal
+	TraceSend      uint8 = 7  // message sent. arg1: receiver pid, arg2: message
+	TraceRecv      uint8 = 8  // message received. arg1: receiver pid, arg2: message
+	TracePtCreate  uint8 = 9  // port created. arg1: port id, arg2: max count
+	TracePtSend    uint8 = 10 // message sent to port. arg1: port id, arg2: message
+	TracePtRecv    uint8 = 11 // message received from port. arg1: port id, arg2: message
+	TracePtDelete  uint8 = 12 // port deleted. arg1: port id
+	TracePtReset   uint8 = 13 // port reset. arg1: port id
+	TraceAlloc     uint8 = 14 // memory allocated. arg1: bytes, arg2: TraceMemHeap, TraceMemStack or pool id
+	TraceFree      uint8 = 15 // memory freed. arg1: bytes, arg2: TraceMemHeap, TraceMemStack or pool id
+)
+
+// memory kinds used as the second argument of TraceAlloc and TraceFree.
+// non-negative values are buffer pool ids
+const (
+	TraceMemHeap  int32 = -1
+	TraceMemStack int32 = -2
+)
+
+// traceNames maps the event kind to the name shown in the timeline
+var traceNames = [...]string{
+	TraceCtxsw:     "ctxsw",
+	TraceReady:     "ready",
+	TraceBlock:     "block",
+	TraceWakeup:    "wakeup",
+	TraceSemWait:   "wait",
+	TraceSemSignal: "signal",
+	TraceSend:      "send",
+	TraceRecv:      "receive",
+	TracePtCreate:  "ptcreate",
+	TracePtSend:    "ptsend",
+	TracePtRecv:    "ptrecv",
+	TracePtDelete:  "ptdelete",
+	TracePtReset:   "ptreset",
+	TraceAlloc:     "alloc",
+	TraceFree:      "free",
+}
+
+// TraceEvent struct is one entry in the trace ring buffer
+type TraceEvent struct {
+	TrTime uint64 // virtual timestamp in microseconds since boot
+	TrKind uint8  // event kind
+	TrPid  Pid32  // process running when the event happened
+	TrArg1 int32  // first event argument, depends on TrKind
+	TrArg2 int32  // second event argument, depends on TrKind
+}
+
+// TraceOn enables or disables event recording
+var TraceOn bool = true
+
+var (
+	// tracebuf is the ring buffer of trace events
+	tracebuf [TraceSize]TraceEvent
+	// tracenext is the index in tracebuf to write the next event
+	tracenext int
+	// tracecount is the number of valid events in tracebuf
+	tracecount int
+
+	// tracelast is the last millisecond an event was recorded in
+	tracelast uint64
+	// tracesub is the number of events recorded during tracelast
+	tracesub uint64
+)
+
+// traceNow function returns the virtual time in microseconds.
+// The clock only counts milliseconds, so events happening in the same
+// millisecond are spread one microsecond apart to keep them ordered.
+func traceNow() uint64 {
+	ms := uint64(clktime)*1000 + uint64(count1000)
+	if ms != tracelast {
+		tracelast = ms
+		tracesub = 0
+	} else if tracesub < 999 {
+		tracesub++
+	}
+
+	return ms*1000 + tracesub
+}
+
+// Trace function records an event of the current process into the ring buffer.
+// It assumes interrupts are disabled, as every kernel call site already does.
+func Trace(kind uint8, arg1 int32, arg2 int32) {
+	if !TraceOn {
+		return
+	}
+
+	ev := &tracebuf[tracenext]
+	ev.TrTime = traceNow()
+	ev.TrKind = kind
+	ev.TrPid = CurrPid
+	ev.TrArg1 = arg1
+	ev.TrArg2 = arg2
+
+	// the oldest event is overwritten when the ring is full
+	tracenext = (tracenext + 1) % TraceSize
+	if tracecount < TraceSize {
+		tracecount++
+	}
+}
+
+// TraceReset function discards all the recorded events
+func TraceReset() {
+	mask := Disable()
+	defer Restore(mask)
+
+	tracenext = 0
+	tracecount = 0
+	tracelast = 0
+	tracesub = 0
+}
+
+// TraceSnapshot function returns the recorded events from the oldest to the newest
+func TraceSnapshot() []TraceEvent {
+	mask := Disable()
+	defer Restore(mask)
+
+	events := make([]TraceEvent, 0, tracecount)
+	start := (tracenext - tracecount + TraceSize) % TraceSize
+	for i := 0; i < tracecount; i++ {
+		events = append(events, tracebuf[(start+i)%TraceSize])
+	}
+
+	return events
+}
+
+// TraceName function returns the printable name of an event kind
+func TraceName(kind uint8) string {
+	if int(kind) >= len(traceNames) || traceNames[kind] == "" {
+		return "unknown"
+	}
+	return traceNames[kind]
+}
+
+// chromeEvent struct is one event of the Chrome trace JSON format.
+// See "Trace Event Format" document of the Chromium project.
+type chromeEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Ph    string                 `json:"ph"`
+	Ts    uint64                 `json:"ts"`
+	Pid   int                    `json:"pid"`
+	Tid   Pid32                  `json:"tid"`
+	Scope string                 `json:"s,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// TraceExport function writes the recorded events to w in the Chrome trace
+// JSON format. Every Xinu process becomes a thread of a single trace process,
+// context switches become the running slices of those threads and all other
+// events are instant events on the thread that caused them.
+func TraceExport(w io.Writer) error {
+	events := TraceSnapshot()
+
+	out := make([]chromeEvent, 0, len(events)+NPROC)
+	named := make(map[Pid32]bool)
+	nameThread := func(pid Pid32) {
+		if named[pid] || pid < 0 || int(pid) >= len(Proctab) {
+			return
+		}
+		named[pid] = true
+		out = append(out, chromeEvent{
+			Name: "thread_name",
+			Ph:   "M",
+			Tid:  pid,
+			Args: map[string]interface{}{"name": procName(pid)},
+		})
+	}
+
+	for _, ev := range events {
+		nameThread(ev.TrPid)
+
+		if ev.TrKind == TraceCtxsw {
+			// close the slice of the old process and open one for the new process
+			oldpid, newpid := Pid32(ev.TrArg1), Pid32(ev.TrArg2)
+			nameThread(oldpid)
+			nameThread(newpid)
+			out = append(out,
+				chromeEvent{Name: "running", Cat: "sched", Ph: "E", Ts: ev.TrTime, Tid: oldpid},
+				chromeEvent{Name: "running", Cat: "sched", Ph: "B", Ts: ev.TrTime, Tid: newpid})
+			continue
+		}
+
+		out = append(out, chromeEvent{
+			Name:  TraceName(ev.TrKind),
+			Cat:   "kernel",
+			Ph:    "i",
+			Ts:    ev.TrTime,
+			Tid:   ev.TrPid,
+			Scope: "t",
+			Args:  map[string]interface{}{"arg1": ev.TrArg1, "arg2": ev.TrArg2},
+		})
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents []chromeEvent `json:"traceEvents"`
+	}{out})
+}
+
+// procName function returns the name of process pid as a string
+func procName(pid Pid32) string {
+	name := Proctab[pid].PrName[:]
+	for i, c := range name {
+		if c == 0 {
+			return string(name[:i])
+		}
+	}
+	return string(name)
+}
@@ -3,5 +3,5 @@ package include
 // UserRet terminate current process.
 // It called when a process returns from the top-level function
 func UserRet() {
-	// Kill(GetPid())
+	Kill(GetPid())
 }
@@ -0,0 +1,111 @@
+/* watchdog.go watchdog for runaway processes
+
+A process that never blocks or yields keeps the CPU as long as no ready
+process has a higher priority, and starves everyone below it. The clock
+handler counts how long the current process has been running since it
+was last switched in (the PrRunTicks field). When that continuous CPU
+time reaches WdLimit milliseconds, the watchdog takes the configured
+action on the process and records an event for later inspection.
+
+There is no counterpart of this file in the original X86 version.
+
+*/
+
+package include
+
+// watchdog actions
+const (
+	WdLog       uint8 = 1 // only report the runaway process
+	WdLowerPrio uint8 = 2 // lower the priority of the runaway process by WdPrioStep
+	WdSuspend   uint8 = 3 // suspend the runaway process
+	WdKill      uint8 = 4 // kill the runaway process
+)
+
+// WdEventSize is the number of watchdog events kept for inspection
+const WdEventSize int = 64
+
+// WdLimit is the continuous CPU time in milliseconds a process may run
+// before the watchdog fires. Zero disables the watchdog
+var WdLimit uint32 = 0
+
+// WdAction is the action taken when the watchdog fires
+var WdAction uint8 = WdLog
+
+// WdPrioStep is how much the priority is lowered by the WdLowerPrio action
+var WdPrioStep Pri16 = 1
+
+// WdEvent struct records one firing of the watchdog
+type WdEvent struct {
+	WeTime   uint32 // milliseconds since boot
+	WePid    Pid32  // the runaway process
+	WeTicks  uint32 // continuous CPU time of the process in milliseconds
+	WePrio   Pri16  // priority of the process when the watchdog fired
+	WeAction uint8  // action taken
+}
+
+var (
+	// wdevents is the ring buffer of watchdog events
+	wdevents [WdEventSize]WdEvent
+	// wdnext is the index in wdevents to write the next event
+	wdnext int
+	// wdcount is the number of valid events in wdevents
+	wdcount int
+)
+
+// WdEvents function returns the recorded watchdog events from the oldest to the newest
+func WdEvents() []WdEvent {
+	mask := Disable()
+	defer Restore(mask)
+
+	events := make([]WdEvent, 0, wdcount)
+	start := (wdnext - wdcount + WdEventSize) % WdEventSize
+	for i := 0; i < wdcount; i++ {
+		events = append(events, wdevents[(start+i)%WdEventSize])
+	}
+
+	return events
+}
+
+// WdTick function is called by the clock handler every millisecond to
+// account the CPU time of the current process and fire the watchdog
+func WdTick() {
+	prptr := &Proctab[CurrPid]
+	prptr.PrRunTicks++
+
+	// the null process is supposed to run forever
+	if WdLimit == 0 || CurrPid == Pid32(NULLProc) || prptr.PrRunTicks < WdLimit {
+		return
+	}
+
+	pid := CurrPid
+	ev := &wdevents[wdnext]
+	ev.WeTime = clktime*1000 + count1000
+	ev.WePid = pid
+	ev.WeTicks = prptr.PrRunTicks
+	ev.WePrio = prptr.PrPrio
+	ev.WeAction = WdAction
+
+	wdnext = (wdnext + 1) % WdEventSize
+	if wdcount < WdEventSize {
+		wdcount++
+	}
+
+	// give the process another WdLimit milliseconds before firing again
+	prptr.PrRunTicks = 0
+
+	switch WdAction {
+	case WdLog:
+		Kprintf(KlWarn, KsSched, "watchdog: pid %d (%s) has run %d ms without blocking", pid, procName(pid), ev.WeTicks)
+	case WdLowerPrio:
+		prio := prptr.PrPrio - WdPrioStep
+		if prio < 1 {
+			prio = 1
+		}
+		ChPrio(pid, prio)
+		Resched() // let a process of higher priority run now
+	case WdSuspend:
+		Suspend(pid)
+	case WdKill:
+		Kill(pid)
+	}
+}